@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+// Behavior is behavior of a server, to reproduce failure modes of
+// endpoints.
+type Behavior struct {
+	// Latency is a distribution of latency before responding.
+	Latency *Latency `json:"latency,omitempty"`
+
+	// Errors are rates to respond error status codes.
+	Errors []ErrorRate `json:"errors,omitempty"`
+
+	// ResetRate is a rate to reset connections without responding.
+	ResetRate float64 `json:"reset_rate,omitempty"`
+
+	// Drip sends a body slowly, chunk by chunk.
+	Drip *Drip `json:"drip,omitempty"`
+
+	// BodySize is size of a body in bytes. A body is padded up to this size.
+	// It can't be used with "json" of EchoFormat.
+	BodySize int `json:"body_size,omitempty"`
+
+	// Echo responds the received request: method, URL, headers and body.
+	Echo bool `json:"echo,omitempty"`
+
+	// EchoFormat is a format of Echo: "raw" (default) or "json".
+	// "json" responds a JSON with a hash of a body instead of the body.
+	EchoFormat string `json:"echo_format,omitempty"`
+}
+
+// Latency is a distribution of latency.
+type Latency struct {
+	// Dist is a name of distribution: "fixed" (default), "uniform",
+	// "normal" or "exponential".
+	Dist string `json:"dist,omitempty"`
+
+	// Min and Max are range for "uniform". Min is lower limit for others.
+	Min seri.Duration `json:"min,omitempty"`
+	Max seri.Duration `json:"max,omitempty"`
+
+	// Mean is used for "fixed", "normal" and "exponential".
+	Mean seri.Duration `json:"mean,omitempty"`
+
+	// StdDev is standard deviation for "normal".
+	StdDev seri.Duration `json:"stddev,omitempty"`
+}
+
+// ErrorRate is a rate to respond an error status code.
+type ErrorRate struct {
+	Rate float64 `json:"rate"`
+
+	// Status is a status code of client or server errors: 400 to 599.
+	Status int `json:"status"`
+}
+
+// Drip is a way to send a body slowly.
+type Drip struct {
+	// Chunk is size of a chunk in bytes.
+	Chunk int `json:"chunk"`
+
+	// Interval is duration between chunks.
+	Interval seri.Duration `json:"interval"`
+}
+
+func (b *Behavior) validate() error {
+	if l := b.Latency; l != nil {
+		switch l.Dist {
+		case "", "fixed", "normal", "exponential":
+		case "uniform":
+			if l.Max < l.Min {
+				return errors.New("latency: max should not be less than min")
+			}
+		default:
+			return fmt.Errorf("latency: unknown distribution: %q", l.Dist)
+		}
+	}
+	switch b.EchoFormat {
+	case "", "raw", "json":
+	default:
+		return fmt.Errorf("echo_format: unknown format: %q", b.EchoFormat)
+	}
+	if b.Echo && b.EchoFormat == "json" && b.BodySize > 0 {
+		// padding breaks JSON.
+		return errors.New("body_size can't be used with echo_format \"json\"")
+	}
+	total := b.ResetRate
+	for _, e := range b.Errors {
+		if e.Status < 400 || e.Status > 599 {
+			return fmt.Errorf("errors: invalid status: %d", e.Status)
+		}
+		total += e.Rate
+	}
+	if total > 1 {
+		return fmt.Errorf("sum of rates of errors and reset_rate exceeds 1: %g", total)
+	}
+	if b.Drip != nil && b.Drip.Chunk <= 0 {
+		return errors.New("drip: chunk should be larger than zero")
+	}
+	return nil
+}
+
+// sample returns a duration which follows the distribution.
+func (l *Latency) sample() time.Duration {
+	var d float64
+	switch l.Dist {
+	case "uniform":
+		d = float64(l.Min) + rand.Float64()*float64(l.Max-l.Min)
+	case "normal":
+		d = float64(l.Mean) + rand.NormFloat64()*float64(l.StdDev)
+	case "exponential":
+		d = rand.ExpFloat64() * float64(l.Mean)
+	default:
+		d = float64(l.Mean)
+	}
+	return time.Duration(math.Max(d, float64(l.Min)))
+}
+
+// fault is a kind of failures for a request.
+type fault struct {
+	reset  bool
+	status int
+}
+
+// pickFault chooses a failure for a request with rates. It returns zero
+// value for successes.
+func (b *Behavior) pickFault() fault {
+	if b.ResetRate == 0 && len(b.Errors) == 0 {
+		return fault{}
+	}
+	x := rand.Float64()
+	if x < b.ResetRate {
+		return fault{reset: true}
+	}
+	x -= b.ResetRate
+	for _, e := range b.Errors {
+		if x < e.Rate {
+			return fault{status: e.Status}
+		}
+		x -= e.Rate
+	}
+	return fault{}
+}
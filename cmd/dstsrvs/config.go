@@ -1,13 +1,58 @@
 package main
 
+import (
+	"encoding/json"
+	"os"
+)
+
 // Config is configuration for program.
 type Config struct {
-	StartPort int
-	Count     int
+	StartPort int `json:"start_port"`
+	Count     int `json:"count"`
+
+	// Default is behavior of servers which are not in Servers.
+	Default *Behavior `json:"default,omitempty"`
+
+	// Servers is behaviors for each server, keyed by ID of server (0 origin).
+	Servers map[int]*Behavior `json:"servers,omitempty"`
 }
 
 // Clone creates a copy of configuration.
 func (cf *Config) Clone() Config {
 	c := *cf
+	if cf.Servers != nil {
+		c.Servers = make(map[int]*Behavior, len(cf.Servers))
+		for k, v := range cf.Servers {
+			c.Servers[k] = v
+		}
+	}
 	return c
 }
+
+// behavior returns behavior of a server.
+func (cf *Config) behavior(id int) *Behavior {
+	if b, ok := cf.Servers[id]; ok && b != nil {
+		return b
+	}
+	if cf.Default != nil {
+		return cf.Default
+	}
+	return &Behavior{}
+}
+
+// LoadConfig loads a configuration from a file.
+func LoadConfig(name string) (*Config, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var c Config
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	err = d.Decode(&c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
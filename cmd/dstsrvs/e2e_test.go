@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/koron/serinin/internal/gocachestore"
+	"github.com/koron/serinin/internal/seri"
+)
+
+// startE2E starts echo servers and a broker for them, and returns URL of the
+// broker and the store of results.
+func startE2E(t *testing.T, count int) (string, seri.Storage) {
+	t.Helper()
+	s, err := NewServer(&Config{
+		Count:   count,
+		Default: &Behavior{Echo: true, EchoFormat: "json"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eps := map[string]seri.Endpoint{}
+	for id := 0; id < count; id++ {
+		ts := httptest.NewServer(s.handler(id))
+		t.Cleanup(ts.Close)
+		eps[fmt.Sprintf("ep%d", id)] = seri.Endpoint{
+			URL: fmt.Sprintf("%s/dst%d?dst=%d", ts.URL, id, id),
+		}
+	}
+	cf := &seri.Config{
+		HTTPClientTimeout: seri.Duration(5 * time.Second),
+		Endpoints:         eps,
+		StoreType:         "gocache",
+		GoCache:           &seri.GoCache{ExpireIn: seri.Duration(time.Minute)},
+		Compression:       &seri.Compression{AcceptEncoding: []string{"gzip"}},
+	}
+	st, err := seri.NewStorage(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := seri.NewBroker(cf, seri.WithStorage(st))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	ts := httptest.NewServer(b.Handler())
+	t.Cleanup(ts.Close)
+	return ts.URL, st
+}
+
+// dispatch sends a request to the broker, and returns ID of the request.
+func dispatch(t *testing.T, req *http.Request) string {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var dr struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&dr); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || dr.RequestID == "" {
+		t.Fatalf("unexpected response: status=%d request_id=%q", resp.StatusCode, dr.RequestID)
+	}
+	return dr.RequestID
+}
+
+// waitResponse polls the store until all endpoints have results.
+func waitResponse(t *testing.T, st seri.Storage, id string, count int) *seri.Response {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < 500; i++ {
+		r, err := st.GetResponse(ctx, id)
+		if err == nil && r != nil && len(r.Results) == count {
+			return r
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("results of %s are not stored", id)
+	return nil
+}
+
+func TestEndToEnd(t *testing.T) {
+	const count = 2
+	brokerURL, st := startE2E(t, count)
+
+	for _, tc := range []struct {
+		method string
+		query  string
+		body   string
+		ct     string
+	}{
+		{method: "GET", query: "a=1&b=2"},
+		{method: "POST", query: "x=y", body: `{"hello":"world"}`, ct: "application/json"},
+	} {
+		var body io.Reader
+		if tc.method == "POST" {
+			body = strings.NewReader(tc.body)
+		}
+		req, err := http.NewRequest(tc.method, brokerURL+"/?"+tc.query, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.ct != "" {
+			req.Header.Set("Content-Type", tc.ct)
+		}
+		// headers of clients are not forwarded to endpoints.
+		req.Header.Set("X-Client-Header", "secret")
+		id := dispatch(t, req)
+
+		r := waitResponse(t, st, id, count)
+		if r.Method != tc.method {
+			t.Errorf("%s: unexpected method of request: %q", tc.method, r.Method)
+		}
+		sum := sha256.Sum256([]byte(tc.body))
+		for i := 0; i < count; i++ {
+			name := fmt.Sprintf("ep%d", i)
+			res := r.Results[name]
+			if res == nil {
+				t.Fatalf("%s: no result of %s", tc.method, name)
+			}
+			if !strings.HasPrefix(res.ContentType, "application/json") {
+				t.Errorf("%s: %s: unexpected content type: %q", tc.method, name, res.ContentType)
+			}
+			var e Echo
+			if err := json.Unmarshal(res.Data, &e); err != nil {
+				t.Fatalf("%s: %s: %s: %s", tc.method, name, err, res.Data)
+			}
+			if e.DstID != i || e.Method != tc.method {
+				t.Errorf("%s: %s: unexpected dst_id and method: %d %s", tc.method, name, e.DstID, e.Method)
+			}
+			if want := fmt.Sprintf("/dst%d", i); e.Path != want {
+				t.Errorf("%s: %s: unexpected path: want=%s got=%s", tc.method, name, want, e.Path)
+			}
+			if want := fmt.Sprintf("dst=%d&%s", i, tc.query); e.Query != want {
+				t.Errorf("%s: %s: unexpected query: want=%s got=%s", tc.method, name, want, e.Query)
+			}
+			h := http.Header(e.Headers)
+			if got := h.Get("Content-Type"); got != tc.ct {
+				t.Errorf("%s: %s: unexpected Content-Type: %q", tc.method, name, got)
+			}
+			if got := h.Get("Accept-Encoding"); got != "gzip" {
+				t.Errorf("%s: %s: unexpected Accept-Encoding: %q", tc.method, name, got)
+			}
+			if got := h.Get("X-Client-Header"); got != "" {
+				t.Errorf("%s: %s: header of client is forwarded: %q", tc.method, name, got)
+			}
+			if e.BodySize != int64(len(tc.body)) || e.BodySHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("%s: %s: unexpected body: size=%d sha256=%s", tc.method, name, e.BodySize, e.BodySHA256)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// Echo is a received request, which is responded as JSON by echo mode.
+type Echo struct {
+	DstID  int   `json:"dst_id"`
+	Serial int64 `json:"serial"`
+
+	Method  string              `json:"method"`
+	Path    string              `json:"path"`
+	Query   string              `json:"query"`
+	Headers map[string][]string `json:"headers"`
+
+	// BodySize and BodySHA256 identify a body, instead of the body itself.
+	BodySize   int64  `json:"body_size"`
+	BodySHA256 string `json:"body_sha256"`
+}
+
+// newEcho reads a request and returns its Echo as JSON.
+func newEcho(r *http.Request, id int, serial int64) ([]byte, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&Echo{
+		DstID:      id,
+		Serial:     serial,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.RawQuery,
+		Headers:    r.Header,
+		BodySize:   n,
+		BodySHA256: hex.EncodeToString(h.Sum(nil)),
+	})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"os"
 
@@ -18,10 +19,46 @@ func main() {
 }
 
 func run(ctx context.Context) error {
-	s, err := NewServer(&Config{
+	var (
+		config string
+		port   int
+		count  int
+		echo   bool
+	)
+	flag.StringVar(&config, "config", "", "path of configuration file for behaviors of servers (optional)")
+	flag.IntVar(&port, "port", 0, "override start_port: port of the first server (default 10001)")
+	flag.IntVar(&count, "count", 0, "override count: number of servers (default 6)")
+	flag.BoolVar(&echo, "echo", false, "respond received requests as JSON by all servers, overrides behaviors of -config")
+	flag.Parse()
+
+	cf := &Config{
 		StartPort: 10001,
 		Count:     6,
-	})
+	}
+	if config != "" {
+		c, err := LoadConfig(config)
+		if err != nil {
+			return err
+		}
+		if c.StartPort == 0 {
+			c.StartPort = cf.StartPort
+		}
+		if c.Count == 0 {
+			c.Count = cf.Count
+		}
+		cf = c
+	}
+	if port > 0 {
+		cf.StartPort = port
+	}
+	if count > 0 {
+		cf.Count = count
+	}
+	if echo {
+		cf.Default = &Behavior{Echo: true, EchoFormat: "json"}
+		cf.Servers = nil
+	}
+	s, err := NewServer(cf)
 	if err != nil {
 		return err
 	}
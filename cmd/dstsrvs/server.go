@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httputil"
 	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -30,45 +35,146 @@ func (s *Server) ServeAll(ctx context.Context) error {
 	if s.cf.Count <= 0 {
 		return fmt.Errorf("no servers to start: %d", s.cf.Count)
 	}
+	for id := 0; id < s.cf.Count; id++ {
+		if err := s.cf.behavior(id).validate(); err != nil {
+			return fmt.Errorf("dstsrv#%d: %w", id, err)
+		}
+	}
+	// all servers are stopped when one of them fails (ex. port in use).
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, s.cf.Count)
 	var wg sync.WaitGroup
 	for i := 0; i < s.cf.Count; i++ {
 		wg.Add(1)
 		go func(n int) {
-			s.serve(ctx, n, s.cf.StartPort+n)
-			wg.Done()
+			defer wg.Done()
+			if err := s.serve(ctx, n, s.cf.StartPort+n); err != nil {
+				s.log.Printf("[ERROR] dstsrv#%d: %s", n, err)
+				errs[n] = fmt.Errorf("dstsrv#%d: %w", n, err)
+				cancel()
+			}
 		}(i)
 	}
 	wg.Wait()
-	return nil
+	return errors.Join(errs...)
 }
 
-func (s *Server) serve(ctx context.Context, id, port int) error {
+// respond writes a response with the behavior.
+func (s *Server) respond(w http.ResponseWriter, r *http.Request, id int, serial int64, b *Behavior) {
+	if b.Latency != nil {
+		time.Sleep(b.Latency.sample())
+	}
+	f := b.pickFault()
+	if f.reset {
+		s.reset(w, id)
+		return
+	}
+
+	status := http.StatusOK
+	ct := "text/plain; charset=utf-8"
+	var body []byte
+	switch {
+	case f.status != 0:
+		status = f.status
+		body = []byte(fmt.Sprintf("dst_id=%d serial=%d status=%d\n", id, serial, status))
+	case b.Echo && b.EchoFormat == "json":
+		ct = "application/json; charset=utf-8"
+		d, err := newEcho(r, id, serial)
+		if err != nil {
+			s.log.Printf("[WARN] dstsrv#%d: failed to echo request: %s", id, err)
+		}
+		body = d
+	case b.Echo:
+		d, err := httputil.DumpRequest(r, true)
+		if err != nil {
+			s.log.Printf("[WARN] dstsrv#%d: failed to dump request: %s", id, err)
+		}
+		body = d
+	default:
+		body = []byte(fmt.Sprintf("dst_id=%d serial=%d\n", id, serial))
+	}
+	if n := b.BodySize - len(body); n > 0 {
+		body = append(body, bytes.Repeat([]byte{'.'}, n)...)
+	}
+
+	w.Header().Add("Content-Type", ct)
+	w.Header().Add("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if b.Drip == nil {
+		w.Write(body)
+		return
+	}
+	fl, _ := w.(http.Flusher)
+	for len(body) > 0 {
+		n := min(b.Drip.Chunk, len(body))
+		if _, err := w.Write(body[:n]); err != nil {
+			return
+		}
+		body = body[n:]
+		if fl != nil {
+			fl.Flush()
+		}
+		if len(body) > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Duration(b.Drip.Interval)):
+			}
+		}
+	}
+}
+
+// reset closes the connection without responding. It sets linger to zero
+// to send RST to the client.
+func (s *Server) reset(w http.ResponseWriter, id int) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		s.log.Printf("[WARN] dstsrv#%d: failed to reset: not hijackable", id)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		s.log.Printf("[WARN] dstsrv#%d: failed to reset: %s", id, err)
+		return
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// handler returns a handler of dstsrv#id.
+func (s *Server) handler(id int) http.Handler {
 	serial := int64(0)
 	getSerial := func() int64 {
 		return atomic.AddInt64(&serial, 1)
 	}
 	sleepKey := fmt.Sprintf("sleep.%d", id)
-	srv := http.Server{
-		Addr: fmt.Sprintf(":%d", port),
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			//s.log.Printf("dstsrv#%d: receive %s", id, r.URL.String())
-			if x := r.URL.Query().Get(sleepKey); x != "" {
-				d, err := time.ParseDuration(x)
-				if err != nil {
-					s.log.Printf("[WARN] dstsrv#%d: invalid sleep: %s", id, err)
-				} else {
-					time.Sleep(d)
-				}
+	b := s.cf.behavior(id)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//s.log.Printf("dstsrv#%d: receive %s", id, r.URL.String())
+		if x := r.URL.Query().Get(sleepKey); x != "" {
+			d, err := time.ParseDuration(x)
+			if err != nil {
+				s.log.Printf("[WARN] dstsrv#%d: invalid sleep: %s", id, err)
+			} else {
+				time.Sleep(d)
 			}
+		}
+		s.respond(w, r, id, getSerial(), b)
+	})
+}
 
-			w.Header().Add("Context-Type", "text/plain; charset=utf-8")
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprintf(w, "dst_id=%d serial=%d\n", id, getSerial())
-		}),
+func (s *Server) serve(ctx context.Context, id, port int) error {
+	srv := http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: s.handler(id),
 	}
 	ctxSrv, cancelSrv := context.WithCancel(context.Background())
 	defer cancelSrv()
-	ch := make(chan error)
+	// ch is buffered, not to leak the goroutine when ListenAndServe fails.
+	ch := make(chan error, 1)
 	go func() {
 		select {
 		case <-ctx.Done():
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateEchoJSON(t *testing.T) {
+	for _, tc := range []struct {
+		b     Behavior
+		valid bool
+	}{
+		{Behavior{Echo: true, EchoFormat: "json"}, true},
+		{Behavior{Echo: true, EchoFormat: "raw", BodySize: 100}, true},
+		{Behavior{BodySize: 100}, true},
+		{Behavior{Echo: true, EchoFormat: "json", BodySize: 100}, false},
+	} {
+		err := tc.b.validate()
+		if (err == nil) != tc.valid {
+			t.Errorf("unexpected validation of %+v: %v", tc.b, err)
+		}
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		valid  bool
+	}{
+		{400, true},
+		{503, true},
+		{599, true},
+		{0, false},
+		{200, false},
+		{302, false},
+		{399, false},
+		{600, false},
+		{999, false},
+	} {
+		b := Behavior{Errors: []ErrorRate{{Rate: 0.1, Status: tc.status}}}
+		if err := b.validate(); (err == nil) != tc.valid {
+			t.Errorf("unexpected validation of status %d: %v", tc.status, err)
+		}
+	}
+}
+
+func TestServeAllPortInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// the second server fails, then the first one is stopped.
+	s, err := NewServer(&Config{StartPort: port - 1, Count: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.log = log.New(io.Discard, "", 0)
+	ch := make(chan error, 1)
+	go func() { ch <- s.ServeAll(context.Background()) }()
+	select {
+	case err := <-ch:
+		if err == nil || !strings.Contains(err.Error(), "dstsrv#1") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeAll doesn't return on failure of a server")
+	}
+}
@@ -1,32 +1,108 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/koron-go/sigctx"
 	"github.com/koron/serinin/internal/seri"
 	_ "github.com/koron/serinin/internal/storages"
 )
 
+// exitMissing is exit code when some requests are not found, or not
+// complete.
+const exitMissing = 2
+
+var errMissing = errors.New("some requests are missing")
+
 func main() {
-	err := run(context.Background())
+	ctx, cancel := sigctx.WithCancelSignal(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	err := run(ctx)
+	if errors.Is(err, errMissing) {
+		os.Exit(exitMissing)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
+type getter struct {
+	st seri.Storage
+
+	// endpoints are names of endpoints to output. All results are output when
+	// it is empty.
+	endpoints []string
+
+	// waitNames are names of endpoints to wait with -wait.
+	waitNames []string
+	wait      time.Duration
+	interval  time.Duration
+
+	raw    bool
+	format string
+
+	// seen keeps names of endpoints which are already printed by -watch, for
+	// each request.
+	seen map[string]map[string]bool
+}
+
 func run(ctx context.Context) error {
-	var storeType string
+	var (
+		config    string
+		storeType string
+		input     string
+		endpoints string
+
+		list    bool
+		del     bool
+		watch   bool
+		since   string
+		until   string
+		opts    seri.ListOptions
+		missing string
+
+		g getter
+	)
+	flag.StringVar(&config, "config", "serinin_config.json", "path of configuration file")
 	flag.StringVar(&storeType, "storetype", "", "override store_type configuration if not empty")
+	flag.StringVar(&input, "input", "", "read request IDs from the file, one ID per line. \"-\" is stdin. stdin is used when no IDs are given")
+	flag.StringVar(&g.format, "format", "jsonl", "output format: \"jsonl\" (a JSON per line) or \"json\" (an array)")
+	flag.StringVar(&endpoints, "endpoint", "", "output results of only these endpoints (comma separated)")
+	flag.BoolVar(&g.raw, "raw", false, "output raw body of a result, requires just one -endpoint")
+	flag.DurationVar(&g.wait, "wait", 0, "wait until results of all endpoints are available, up to the duration")
+	flag.DurationVar(&g.interval, "interval", 500*time.Millisecond, "interval to poll with -wait and -watch")
+	flag.BoolVar(&watch, "watch", false, "print results as they arrive, until all endpoints are available or -wait passes. default -wait is the longest timeout of endpoints plus a margin")
+
+	flag.BoolVar(&list, "list", false, "list recent requests instead of getting responses")
+	flag.BoolVar(&del, "delete", false, "delete requests instead of getting responses")
+	flag.StringVar(&since, "since", "", "list requests since the time: RFC3339 or duration before now (ex. \"10m\")")
+	flag.StringVar(&until, "until", "", "list requests until the time: RFC3339 or duration before now")
+	flag.StringVar(&opts.URLPrefix, "url", "", "list requests which URL has the prefix")
+	flag.StringVar(&opts.Method, "method", "", "list requests of the method")
+	flag.StringVar(&missing, "missing", "", "list requests which lack results of any of endpoints (comma separated)")
+	flag.IntVar(&opts.Limit, "limit", seri.DefaultListLimit, "max number of requests to list")
 	flag.Parse()
-	if flag.NArg() == 0 {
+
+	if endpoints != "" {
+		g.endpoints = strings.Split(endpoints, ",")
+	}
+	if err := g.validate(); err != nil {
+		return err
 	}
 
-	c, err := seri.LoadConfig("serinin_config.json")
+	c, err := seri.LoadConfig(config)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
@@ -34,24 +110,395 @@ func run(ctx context.Context) error {
 		log.Printf("[INFO] store_type is overridden: %s -> %s", c.StoreType, storeType)
 		c.StoreType = storeType
 	}
+	g.waitNames = g.endpoints
+	if len(g.waitNames) == 0 {
+		g.waitNames = c.EntryPointNames()
+	}
 	st, err := seri.NewStorage(c)
 	if err != nil {
 		return err
 	}
+	if c, ok := st.(io.Closer); ok {
+		defer c.Close()
+	}
+	g.st = st
 
-	enc := json.NewEncoder(os.Stdout)
-	enc.SetIndent("  ", "  ")
-	for _, id := range flag.Args() {
-		r, err := st.GetResponse(id)
+	if list {
+		now := time.Now()
+		if since != "" {
+			opts.Since, err = seri.ParseTime(since, now)
+			if err != nil {
+				return fmt.Errorf("invalid -since: %w", err)
+			}
+		}
+		if until != "" {
+			opts.Until, err = seri.ParseTime(until, now)
+			if err != nil {
+				return fmt.Errorf("invalid -until: %w", err)
+			}
+		}
+		if missing != "" {
+			opts.Missing = strings.Split(missing, ",")
+		}
+		return listRequests(ctx, st, &opts)
+	}
+
+	ids, err := readIDs(input, flag.Args())
+	if err != nil {
+		return err
+	}
+	if del {
+		return deleteRequests(ctx, st, ids)
+	}
+	if watch {
+		if g.wait <= 0 {
+			g.wait = watchWait(c, g.waitNames)
+			if g.wait <= 0 {
+				return errors.New("-watch requires -wait, because endpoints have no timeout")
+			}
+		}
+		return g.watch(ctx, ids)
+	}
+	return g.getResponses(ctx, ids)
+}
+
+// validate checks combination of options for output.
+func (g *getter) validate() error {
+	switch g.format {
+	case "json", "jsonl":
+	default:
+		return fmt.Errorf("unsupported -format: %q", g.format)
+	}
+	if g.raw && len(g.endpoints) != 1 {
+		return errors.New("-raw requires just one -endpoint")
+	}
+	return nil
+}
+
+// readIDs reads request IDs from a file or stdin, when no IDs are given as
+// arguments. Empty lines and lines which start with "#" are ignored.
+func readIDs(input string, args []string) ([]string, error) {
+	if input == "" {
+		if len(args) > 0 {
+			return args, nil
+		}
+		input = "-"
+	}
+	r := io.Reader(os.Stdin)
+	if input != "-" {
+		f, err := os.Open(input)
 		if err != nil {
-			fmt.Printf("%s: failed: %s\n", id, err)
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	ids := append([]string{}, args...)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
 			continue
 		}
-		fmt.Printf("%s: ", id)
-		enc.Encode(r)
-		fmt.Println()
+		ids = append(ids, s)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// getResponses outputs responses of requests. It returns errMissing when
+// some of them are not found, or not complete with -wait.
+func (g *getter) getResponses(ctx context.Context, ids []string) error {
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+	var all []*seri.Response
+	nmiss := 0
+	for _, id := range ids {
+		r, err := g.get(ctx, id)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			log.Printf("[WARN] %s: %s", id, err)
+			nmiss++
+			if r == nil {
+				continue
+			}
+		}
+		g.filter(r)
+		switch {
+		case g.raw:
+			res, ok := r.Results[g.endpoints[0]]
+			if !ok {
+				if err == nil {
+					log.Printf("[WARN] %s: no results of %s", id, g.endpoints[0])
+					nmiss++
+				}
+				continue
+			}
+			out.Write(res.Data)
+		case g.format == "json":
+			all = append(all, r)
+		default:
+			enc.Encode(r)
+		}
+	}
+	if g.format == "json" && !g.raw {
+		if all == nil {
+			all = []*seri.Response{}
+		}
+		enc.SetIndent("", "  ")
+		enc.Encode(all)
+	}
+	if nmiss > 0 {
+		return errMissing
+	}
+	return nil
+}
 
+// get gets a response of a request. With -wait, it polls until all
+// endpoints have results or marks. When it gives up waiting, it returns the
+// last response with an error.
+func (g *getter) get(ctx context.Context, id string) (*seri.Response, error) {
+	r, err := g.getOnce(ctx, id)
+	if g.wait <= 0 || (err == nil && g.complete(r)) {
+		return r, err
 	}
+	ctx, cancel := context.WithTimeout(ctx, g.wait)
+	defer cancel()
+	tk := time.NewTicker(g.interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = errors.New("not complete")
+			}
+			if errors.Is(ctx.Err(), context.Canceled) {
+				err = ctx.Err()
+			}
+			return r, err
+		case <-tk.C:
+		}
+		r2, err2 := g.getOnce(ctx, id)
+		if errors.Is(err2, context.DeadlineExceeded) {
+			continue
+		}
+		r, err = r2, err2
+		if err == nil && g.complete(r) {
+			return r, nil
+		}
+	}
+}
 
+func (g *getter) getOnce(ctx context.Context, id string) (*seri.Response, error) {
+	r, err := g.st.GetResponse(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// some stores return an empty response for unknown requests.
+	if r == nil || r.ID == "" {
+		return nil, fmt.Errorf("%w: %s", seri.ErrNotFound, id)
+	}
+	return r, nil
+}
+
+// complete checks all endpoints to wait have results or marks.
+func (g *getter) complete(r *seri.Response) bool {
+	for _, name := range g.waitNames {
+		if _, ok := r.Results[name]; ok {
+			continue
+		}
+		if _, ok := r.Marks[name]; ok {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// filter removes results and marks of endpoints which are not specified by
+// -endpoint.
+func (g *getter) filter(r *seri.Response) {
+	if len(g.endpoints) == 0 {
+		return
+	}
+	keep := make(map[string]bool, len(g.endpoints))
+	for _, name := range g.endpoints {
+		keep[name] = true
+	}
+	for name := range r.Results {
+		if !keep[name] {
+			delete(r.Results, name)
+		}
+	}
+	for name := range r.Marks {
+		if !keep[name] {
+			delete(r.Marks, name)
+		}
+	}
+}
+
+type watchEvent struct {
+	ID       string       `json:"id"`
+	Endpoint string       `json:"endpoint"`
+	Time     time.Time    `json:"time"`
+	Result   *seri.Result `json:"result,omitempty"`
+	Mark     string       `json:"mark,omitempty"`
+}
+
+// watchMargin is a margin of default -wait for -watch, for delays of queues
+// of serinin and writes to the store.
+const watchMargin = 5 * time.Second
+
+// watchWait returns default -wait for -watch: the longest timeout of
+// endpoints to wait, plus watchMargin. Results of endpoints never appear
+// after it. It returns zero when some of endpoints have no timeout.
+func watchWait(c *seri.Config, names []string) time.Duration {
+	var max time.Duration
+	for _, name := range names {
+		to := time.Duration(c.HTTPClientTimeout)
+		if ep, ok := c.Endpoints[name]; ok && ep.Timeout > 0 {
+			to = time.Duration(ep.Timeout)
+		}
+		if to <= 0 {
+			return 0
+		}
+		if to > max {
+			max = to
+		}
+	}
+	if max <= 0 {
+		return 0
+	}
+	return max + watchMargin
+}
+
+// watch polls responses of requests, and prints each result or mark of
+// endpoints as a JSON line when it appears. It ends when all endpoints of
+// all requests are available, or -wait passes. It returns errMissing when
+// some of them are not complete.
+func (g *getter) watch(ctx context.Context, ids []string) error {
+	ctx, cancel := context.WithTimeout(ctx, g.wait)
+	defer cancel()
+	g.seen = make(map[string]map[string]bool, len(ids))
+	enc := json.NewEncoder(os.Stdout)
+	tk := time.NewTicker(g.interval)
+	defer tk.Stop()
+	pending := ids
+	for {
+		var next []string
+		for _, id := range pending {
+			r, err := g.getOnce(ctx, id)
+			if err != nil && !errors.Is(err, seri.ErrNotFound) && ctx.Err() == nil {
+				log.Printf("[WARN] %s: %s", id, err)
+			}
+			if r != nil {
+				g.filter(r)
+				for _, ev := range g.newEvents(r) {
+					enc.Encode(ev)
+				}
+				if g.complete(r) {
+					continue
+				}
+			}
+			next = append(next, id)
+		}
+		pending = next
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return ctx.Err()
+			}
+			for _, id := range pending {
+				log.Printf("[WARN] %s: not complete", id)
+			}
+			return errMissing
+		case <-tk.C:
+		}
+	}
+}
+
+// newEvents returns events for results and marks of a response which are
+// not printed yet.
+func (g *getter) newEvents(r *seri.Response) []*watchEvent {
+	seen, ok := g.seen[r.ID]
+	if !ok {
+		seen = map[string]bool{}
+		g.seen[r.ID] = seen
+	}
+	now := time.Now()
+	var events []*watchEvent
+	for name, res := range r.Results {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		events = append(events, &watchEvent{ID: r.ID, Endpoint: name, Time: now, Result: res})
+	}
+	for name, mark := range r.Marks {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		events = append(events, &watchEvent{ID: r.ID, Endpoint: name, Time: now, Mark: mark})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Endpoint < events[j].Endpoint
+	})
+	return events
+}
+
+// listRequests prints summaries of recent requests as JSON lines.
+func listRequests(ctx context.Context, st seri.Storage, opts *seri.ListOptions) error {
+	l, ok := st.(seri.Lister)
+	if !ok {
+		return seri.ErrNotSupported
+	}
+	list, err := l.ListRequests(ctx, opts)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, ri := range list {
+		enc.Encode(ri)
+	}
+	return nil
+}
+
+type deleteResult struct {
+	ID      string `json:"id"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// deleteRequests deletes requests with their results, and prints results of
+// deletion as JSON lines. It returns errMissing when some of them are not
+// deleted.
+func deleteRequests(ctx context.Context, st seri.Storage, ids []string) error {
+	d, ok := st.(seri.Deleter)
+	if !ok {
+		return seri.ErrNotSupported
+	}
+	enc := json.NewEncoder(os.Stdout)
+	nmiss := 0
+	for _, id := range ids {
+		dr := &deleteResult{ID: id, Deleted: true}
+		if err := d.DeleteRequest(ctx, id); err != nil {
+			dr.Deleted = false
+			dr.Error = err.Error()
+			nmiss++
+		}
+		enc.Encode(dr)
+	}
+	if nmiss > 0 {
+		return errMissing
+	}
 	return nil
 }
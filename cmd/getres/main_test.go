@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+func TestWatchWait(t *testing.T) {
+	c := &seri.Config{
+		HTTPClientTimeout: seri.Duration(3 * time.Second),
+		Endpoints: map[string]seri.Endpoint{
+			"ep1": {URL: "http://127.0.0.1:10001/"},
+			"ep2": {URL: "http://127.0.0.1:10002/", Timeout: seri.Duration(10 * time.Second)},
+			"ep3": {URL: "http://127.0.0.1:10003/", Timeout: seri.Duration(time.Second)},
+		},
+	}
+	for _, tc := range []struct {
+		names []string
+		want  time.Duration
+	}{
+		{[]string{"ep1", "ep2", "ep3"}, 10*time.Second + watchMargin},
+		{[]string{"ep1", "ep3"}, 3*time.Second + watchMargin},
+		{[]string{"ep3"}, time.Second + watchMargin},
+		{nil, 0},
+	} {
+		if got := watchWait(c, tc.names); got != tc.want {
+			t.Errorf("unexpected wait for %v: want=%s got=%s", tc.names, tc.want, got)
+		}
+	}
+
+	// endpoints without timeout never end.
+	c.HTTPClientTimeout = 0
+	if got := watchWait(c, []string{"ep1", "ep2"}); got != 0 {
+		t.Errorf("unexpected wait without timeout: %s", got)
+	}
+}
+
+// writeFile writes s to a temporary file, and returns its path.
+func writeFile(t *testing.T, s string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(name, []byte(s), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// setStdin replaces os.Stdin with a file which has s, during the test.
+func setStdin(t *testing.T, s string) {
+	t.Helper()
+	f, err := os.Open(writeFile(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = orig
+		f.Close()
+	})
+}
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	orig := os.Stdout
+	os.Stdout = f
+	defer func() { os.Stdout = orig }()
+	fn()
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestReadIDs(t *testing.T) {
+	const input = "r1\n\n# comment\n  r2  \n\t\n#r3\nr4\n"
+	for _, tc := range []struct {
+		name  string
+		input string
+		stdin string
+		args  []string
+		want  string
+	}{
+		{"args", "", "r9\n", []string{"a1", "a2"}, "a1,a2"},
+		{"stdin without args", "", input, nil, "r1,r2,r4"},
+		{"stdin", "-", input, []string{"a1"}, "a1,r1,r2,r4"},
+		{"file", writeFile(t, input), "r9\n", []string{"a1"}, "a1,r1,r2,r4"},
+	} {
+		setStdin(t, tc.stdin)
+		ids, err := readIDs(tc.input, tc.args)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if got := strings.Join(ids, ","); got != tc.want {
+			t.Errorf("%s: unexpected IDs: want=%s got=%s", tc.name, tc.want, got)
+		}
+	}
+
+	if _, err := readIDs(filepath.Join(t.TempDir(), "none"), nil); err == nil {
+		t.Error("missing file should fail")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		g  getter
+		ok bool
+	}{
+		{getter{format: "jsonl"}, true},
+		{getter{format: "json"}, true},
+		{getter{format: "csv"}, false},
+		{getter{format: "jsonl", raw: true, endpoints: []string{"a"}}, true},
+		{getter{format: "jsonl", raw: true}, false},
+		{getter{format: "jsonl", raw: true, endpoints: []string{"a", "b"}}, false},
+	} {
+		if err := tc.g.validate(); (err == nil) != tc.ok {
+			t.Errorf("unexpected validation for %+v: %v", tc.g, err)
+		}
+	}
+}
+
+// newTestGetter creates a getter with a store which has requests r1 and r2,
+// for endpoints "a" and "b".
+func newTestGetter(t *testing.T) *getter {
+	t.Helper()
+	st, err := seri.NewStorage(&seri.Config{
+		Endpoints: map[string]seri.Endpoint{"a": {}, "b": {}},
+		StoreType: "gocache",
+		GoCache:   &seri.GoCache{ExpireIn: seri.Duration(time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, id := range []string{"r1", "r2"} {
+		if err := st.StoreRequest(ctx, id, "GET", "/"+id); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.StoreResponse(ctx, id, "a", []byte(id+"/a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.(seri.Marker).MarkResponse(ctx, "r1", "b", seri.MarkAborted); err != nil {
+		t.Fatal(err)
+	}
+	return &getter{st: st, format: "jsonl", waitNames: []string{"a", "b"}}
+}
+
+func TestGetResponses(t *testing.T) {
+	g := newTestGetter(t)
+	var err error
+	out := captureStdout(t, func() {
+		err = g.getResponses(context.Background(), []string{"r1", "r2"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	for i, id := range []string{"r1", "r2"} {
+		var r seri.Response
+		if err := json.Unmarshal([]byte(lines[i]), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.ID != id || string(r.Results["a"].Data) != id+"/a" {
+			t.Errorf("unexpected response: %s", lines[i])
+		}
+	}
+
+	// json outputs an array.
+	g.format = "json"
+	out = captureStdout(t, func() {
+		err = g.getResponses(context.Background(), []string{"r1", "r2"})
+	})
+	var all []*seri.Response
+	if err := json.Unmarshal([]byte(out), &all); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if len(all) != 2 || all[0].ID != "r1" || all[1].ID != "r2" {
+		t.Errorf("unexpected output: %s", out)
+	}
+	out = captureStdout(t, func() {
+		err = g.getResponses(context.Background(), nil)
+	})
+	if strings.TrimSpace(out) != "[]" {
+		t.Errorf("unexpected output for no requests: %s", out)
+	}
+}
+
+func TestGetResponsesFilter(t *testing.T) {
+	g := newTestGetter(t)
+	g.endpoints = []string{"b"}
+	var err error
+	out := captureStdout(t, func() {
+		err = g.getResponses(context.Background(), []string{"r1"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r seri.Response
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Results) != 0 || len(r.Marks) != 1 || r.Marks["b"] != seri.MarkAborted {
+		t.Errorf("results are not filtered: %s", out)
+	}
+
+	// -raw outputs a body of the endpoint.
+	g.endpoints = []string{"a"}
+	g.raw = true
+	out = captureStdout(t, func() {
+		err = g.getResponses(context.Background(), []string{"r1", "r2"})
+	})
+	if err != nil || out != "r1/ar2/a" {
+		t.Errorf("unexpected raw output: %q %v", out, err)
+	}
+	// a request without a result of the endpoint is missing.
+	g.endpoints = []string{"b"}
+	out = captureStdout(t, func() {
+		err = g.getResponses(context.Background(), []string{"r1"})
+	})
+	if !errors.Is(err, errMissing) || out != "" {
+		t.Errorf("unexpected raw output without result: %q %v", out, err)
+	}
+}
+
+func TestGetResponsesMissing(t *testing.T) {
+	g := newTestGetter(t)
+	var err error
+	out := captureStdout(t, func() {
+		err = g.getResponses(context.Background(), []string{"r1", "unknown", "r2"})
+	})
+	// found responses are output, and it exits with exitMissing.
+	if !errors.Is(err, errMissing) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if exitMissing != 2 {
+		t.Errorf("unexpected exit code: %d", exitMissing)
+	}
+	if n := strings.Count(out, "\n"); n != 2 {
+		t.Errorf("unexpected output: %s", out)
+	}
+
+	// requests which are not complete are missing with -wait.
+	g.wait = 30 * time.Millisecond
+	g.interval = 10 * time.Millisecond
+	out = captureStdout(t, func() {
+		err = g.getResponses(context.Background(), []string{"r1", "r2"})
+	})
+	if !errors.Is(err, errMissing) {
+		t.Errorf("unexpected error for incomplete requests: %v", err)
+	}
+	// the last response of r2 is still output.
+	if n := strings.Count(out, "\n"); n != 2 {
+		t.Errorf("unexpected output: %s", out)
+	}
+}
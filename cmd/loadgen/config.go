@@ -0,0 +1,34 @@
+package main
+
+import "time"
+
+// Config is configuration for program.
+type Config struct {
+	// Target is URL of serinin.
+	Target string
+
+	Method      string
+	Query       string
+	Body        string
+	ContentType string
+
+	QPS         float64
+	Concurrency int
+	Duration    time.Duration
+	Count       int
+	Timeout     time.Duration
+
+	// Settle is max duration to wait results of endpoints after sending.
+	Settle   time.Duration
+	Interval time.Duration
+
+	// Endpoints are names of endpoints which serinin dispatches to.
+	Endpoints []string
+}
+
+// Clone creates a copy of configuration.
+func (cf *Config) Clone() Config {
+	c := *cf
+	c.Endpoints = append([]string(nil), cf.Endpoints...)
+	return c
+}
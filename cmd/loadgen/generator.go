@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+	"golang.org/x/time/rate"
+)
+
+// Generator sends requests to serinin, and reads their results back.
+type Generator struct {
+	cf  Config
+	log *log.Logger
+
+	query *template.Template
+	body  *template.Template
+
+	client *http.Client
+	lim    *rate.Limiter
+	serial int64
+}
+
+// templateData is data for templates of query and body.
+type templateData struct {
+	// N is serial number of a request, starts with 1.
+	N int64
+}
+
+var templateFuncs = template.FuncMap{
+	// rand returns a random integer in [lo, hi).
+	"rand": func(lo, hi int) int {
+		if hi <= lo {
+			return lo
+		}
+		return lo + rand.Intn(hi-lo)
+	},
+}
+
+// NewGenerator creates new `Generator`
+func NewGenerator(cf *Config) (*Generator, error) {
+	switch cf.Method {
+	case "GET", "POST":
+	default:
+		return nil, fmt.Errorf("unsupported method: %s", cf.Method)
+	}
+	if cf.Concurrency <= 0 {
+		return nil, fmt.Errorf("concurrency should be larger than zero: %d", cf.Concurrency)
+	}
+	query, err := template.New("query").Funcs(templateFuncs).Parse(cf.Query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query template: %w", err)
+	}
+	body, err := template.New("body").Funcs(templateFuncs).Parse(cf.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+	g := &Generator{
+		cf:    cf.Clone(),
+		log:   log.New(os.Stderr, "", log.LstdFlags),
+		query: query,
+		body:  body,
+		client: &http.Client{
+			Timeout: cf.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: cf.Concurrency,
+			},
+		},
+	}
+	if cf.QPS > 0 {
+		g.lim = rate.NewLimiter(rate.Limit(cf.QPS), 1)
+	}
+	return g, nil
+}
+
+// sample is a result of a request to serinin.
+type sample struct {
+	ID       string
+	Latency  time.Duration
+	Err      error
+	Timeout  bool
+	Degraded bool
+}
+
+// Run sends requests until -duration passes or -n requests are sent, then
+// reads their results from the storage when st is not nil. Requests in
+// flight at end of -duration are not canceled, they end with -timeout.
+func (g *Generator) Run(ctx context.Context, st seri.Storage) (*Report, error) {
+	sendCtx, cancel := context.WithTimeout(ctx, g.cf.Duration)
+	defer cancel()
+	var (
+		mu      sync.Mutex
+		samples []*sample
+		wg      sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < g.cf.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n, ok := g.next(sendCtx)
+				if !ok {
+					return
+				}
+				s := g.send(ctx, n)
+				if s == nil {
+					return
+				}
+				mu.Lock()
+				samples = append(samples, s)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	rep := newReport(&g.cf, samples, time.Since(start))
+	g.log.Printf("[INFO] loadgen: sent %d requests", rep.Sent)
+	if st == nil {
+		return rep, ctx.Err()
+	}
+	err := g.verify(ctx, st, rep, samples)
+	return rep, err
+}
+
+// next returns serial number of next request, or false when no more requests
+// should be sent.
+func (g *Generator) next(ctx context.Context) (int64, bool) {
+	if g.lim != nil {
+		if err := g.lim.Wait(ctx); err != nil {
+			return 0, false
+		}
+	}
+	if ctx.Err() != nil {
+		return 0, false
+	}
+	n := atomic.AddInt64(&g.serial, 1)
+	if g.cf.Count > 0 && n > int64(g.cf.Count) {
+		return 0, false
+	}
+	return n, true
+}
+
+func (g *Generator) newRequest(ctx context.Context, n int64) (*http.Request, error) {
+	data := &templateData{N: n}
+	var qs strings.Builder
+	if err := g.query.Execute(&qs, data); err != nil {
+		return nil, err
+	}
+	u := g.cf.Target
+	if qs.Len() > 0 {
+		u += "?" + qs.String()
+	}
+	if g.cf.Method == "GET" {
+		return http.NewRequestWithContext(ctx, "GET", u, nil)
+	}
+	var body bytes.Buffer
+	if err := g.body.Execute(&body, data); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", g.cf.ContentType)
+	return req, nil
+}
+
+type dispatchResponse struct {
+	RequestID string `json:"request_id"`
+	Degraded  bool   `json:"degraded"`
+	Title     string `json:"title"`
+	Detail    string `json:"detail"`
+}
+
+// send sends a request to serinin. It returns nil when the request is
+// canceled by interruption.
+func (g *Generator) send(ctx context.Context, n int64) *sample {
+	req, err := g.newRequest(ctx, n)
+	if err != nil {
+		return &sample{Err: err}
+	}
+	start := time.Now()
+	resp, err := g.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		var x interface{ Timeout() bool }
+		return &sample{
+			Latency: time.Since(start),
+			Err:     err,
+			Timeout: errors.As(err, &x) && x.Timeout(),
+		}
+	}
+	defer resp.Body.Close()
+	var dr dispatchResponse
+	err = json.NewDecoder(resp.Body).Decode(&dr)
+	io.Copy(io.Discard, resp.Body)
+	s := &sample{
+		ID:       dr.RequestID,
+		Latency:  time.Since(start),
+		Degraded: dr.Degraded,
+	}
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		s.Err = fmt.Errorf("status %d: %s: %s", resp.StatusCode, dr.Title, dr.Detail)
+	case err != nil:
+		s.Err = fmt.Errorf("failed to decode response: %w", err)
+	case dr.RequestID == "":
+		s.Err = errors.New("no request_id in response")
+	}
+	return s
+}
+
+// verify polls results of succeeded requests until all endpoints have
+// results or marks, or -settle passes.
+func (g *Generator) verify(ctx context.Context, st seri.Storage, rep *Report, samples []*sample) error {
+	pending := make([]string, 0, len(samples))
+	for _, s := range samples {
+		if s.Err == nil {
+			pending = append(pending, s.ID)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, g.cf.Settle)
+	defer cancel()
+	last := make(map[string]*seri.Response, len(pending))
+	tk := time.NewTicker(g.cf.Interval)
+	defer tk.Stop()
+	for len(pending) > 0 {
+		var next []string
+		for _, id := range pending {
+			r, err := st.GetResponse(ctx, id)
+			// requests which are not stored yet are pending. some stores
+			// return an empty response for them.
+			if errors.Is(err, seri.ErrNotFound) || (err == nil && (r == nil || r.ID == "")) {
+				next = append(next, id)
+				continue
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					g.log.Printf("[WARN] loadgen: reqid=%s: failed to get: %s", id, err)
+				}
+				next = append(next, id)
+				continue
+			}
+			last[id] = r
+			if !g.complete(r) {
+				next = append(next, id)
+			}
+		}
+		pending = next
+		if len(pending) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			pending = nil
+		case <-tk.C:
+		}
+	}
+	rep.addResults(g.cf.Endpoints, last)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// complete checks all endpoints have results or marks.
+func (g *Generator) complete(r *seri.Response) bool {
+	for _, name := range g.cf.Endpoints {
+		if _, ok := r.Results[name]; ok {
+			continue
+		}
+		if _, ok := r.Marks[name]; ok {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+// fakeStore is a Storage which has results of requests after they are got
+// some times.
+type fakeStore struct {
+	mu    sync.Mutex
+	after int
+	gets  map[string]int
+}
+
+func (fs *fakeStore) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	return nil
+}
+
+func (fs *fakeStore) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return nil
+}
+
+func (fs *fakeStore) GetResponse(ctx context.Context, reqid string) (*seri.Response, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.gets[reqid]++
+	if fs.gets[reqid] <= fs.after {
+		return nil, fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+	}
+	return &seri.Response{
+		ID:      reqid,
+		Results: map[string]*seri.Result{"ep1": {Data: []byte("hello")}},
+	}, nil
+}
+
+// newFakeSerinin starts a server which responds request IDs after delay.
+func newFakeSerinin(t *testing.T, delay time.Duration) *httptest.Server {
+	t.Helper()
+	var (
+		mu sync.Mutex
+		n  int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		mu.Lock()
+		n++
+		id := fmt.Sprintf("r%d", n)
+		mu.Unlock()
+		fmt.Fprintf(w, `{"request_id":%q,"endpoints":["ep1"]}`, id)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestGenerator(t *testing.T, cf *Config) (*Generator, *bytes.Buffer) {
+	t.Helper()
+	cf.Method = "GET"
+	cf.Concurrency = 1
+	cf.Timeout = time.Second
+	cf.Settle = time.Second
+	cf.Interval = 10 * time.Millisecond
+	cf.Endpoints = []string{"ep1"}
+	g, err := NewGenerator(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb := &bytes.Buffer{}
+	g.log = log.New(bb, "", 0)
+	return g, bb
+}
+
+func TestInFlightAtEndOfDuration(t *testing.T) {
+	srv := newFakeSerinin(t, 200*time.Millisecond)
+	g, _ := newTestGenerator(t, &Config{Target: srv.URL, Duration: 50 * time.Millisecond})
+	rep, err := g.Run(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Sent != 1 || rep.Failed != 0 {
+		t.Errorf("request in flight is dropped or failed: sent=%d failed=%d", rep.Sent, rep.Failed)
+	}
+}
+
+func TestVerifyPending(t *testing.T) {
+	srv := newFakeSerinin(t, 0)
+	g, bb := newTestGenerator(t, &Config{Target: srv.URL, Duration: time.Second, Count: 3})
+	st := &fakeStore{after: 3, gets: map[string]int{}}
+	rep, err := g.Run(context.Background(), st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Sent != 3 || rep.Complete != 3 {
+		t.Errorf("unexpected report: sent=%d complete=%d", rep.Sent, rep.Complete)
+	}
+	if bytes.Contains(bb.Bytes(), []byte("[WARN]")) {
+		t.Errorf("not found requests are logged as warnings:\n%s", bb.String())
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/koron-go/sigctx"
+	"github.com/koron/serinin/internal/seri"
+	_ "github.com/koron/serinin/internal/storages"
+)
+
+func main() {
+	ctx, cancel := sigctx.WithCancelSignal(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	err := run(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context) error {
+	var (
+		config    string
+		storeType string
+		noVerify  bool
+		asJSON    bool
+		cf        Config
+	)
+	flag.StringVar(&config, "config", "serinin_config.json", "path of configuration file of serinin")
+	flag.StringVar(&storeType, "storetype", "", "override store_type configuration if not empty")
+	flag.StringVar(&cf.Target, "target", "", "URL of serinin (default: derived from \"addr\" of configuration)")
+	flag.StringVar(&cf.Method, "method", "GET", "method of requests: GET or POST")
+	flag.StringVar(&cf.Query, "query", "", "template of query string (ex. \"sleep.0={{rand 0 500}}ms&n={{.N}}\")")
+	flag.StringVar(&cf.Body, "body", "", "template of body for POST")
+	flag.StringVar(&cf.ContentType, "contenttype", "application/json", "Content-Type of body for POST")
+	flag.Float64Var(&cf.QPS, "qps", 0, "target requests per second. zero means as fast as -concurrency allows")
+	flag.IntVar(&cf.Concurrency, "concurrency", 8, "number of concurrent requests")
+	flag.DurationVar(&cf.Duration, "duration", 10*time.Second, "duration to send requests")
+	flag.IntVar(&cf.Count, "n", 0, "number of requests to send. zero means unlimited within -duration")
+	flag.DurationVar(&cf.Timeout, "timeout", 10*time.Second, "timeout of each request to serinin")
+	flag.DurationVar(&cf.Settle, "settle", 10*time.Second, "max duration to wait results of endpoints after sending")
+	flag.DurationVar(&cf.Interval, "interval", 500*time.Millisecond, "interval to poll results")
+	flag.BoolVar(&noVerify, "noverify", false, "don't read results back from the store")
+	flag.BoolVar(&asJSON, "json", false, "output the report as JSON")
+	flag.Parse()
+
+	c, err := seri.LoadConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if storeType != "" {
+		log.Printf("[INFO] store_type is overridden: %s -> %s", c.StoreType, storeType)
+		c.StoreType = storeType
+	}
+	if cf.Target == "" {
+		cf.Target, err = targetURL(c.Addr)
+		if err != nil {
+			return err
+		}
+	}
+	cf.Endpoints = c.EntryPointNames()
+
+	var st seri.Storage
+	if !noVerify {
+		st, err = seri.NewStorage(c)
+		if err != nil {
+			return err
+		}
+		if c, ok := st.(io.Closer); ok {
+			defer c.Close()
+		}
+	}
+
+	g, err := NewGenerator(&cf)
+	if err != nil {
+		return err
+	}
+	rep, err := g.Run(ctx, st)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	if asJSON {
+		return rep.WriteJSON(os.Stdout)
+	}
+	return rep.WriteText(os.Stdout)
+}
+
+// targetURL derives URL of serinin from "addr" configuration.
+func targetURL(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive target from addr %q: %w", addr, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return "http://" + host + ":" + port + "/", nil
+}
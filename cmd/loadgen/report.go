@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+// Report is a result of load generation.
+type Report struct {
+	Target  string        `json:"target"`
+	Elapsed seri.Duration `json:"elapsed"`
+
+	Sent     int `json:"sent"`
+	Failed   int `json:"failed"`
+	Timeouts int `json:"timeouts"`
+	Degraded int `json:"degraded"`
+
+	// QPS is actual number of requests per second.
+	QPS float64 `json:"qps"`
+
+	// Latency is percentiles of dispatch latency, which is duration to
+	// receive a response of serinin.
+	Latency Percentiles `json:"latency"`
+
+	// Verified is true when results are read back from the store.
+	Verified bool `json:"verified"`
+
+	// Complete is number of requests which all endpoints have results or
+	// marks.
+	Complete int `json:"complete"`
+
+	Endpoints []*EndpointStat `json:"endpoints,omitempty"`
+}
+
+// Percentiles is percentiles of latency.
+type Percentiles struct {
+	Min seri.Duration `json:"min"`
+	P50 seri.Duration `json:"p50"`
+	P90 seri.Duration `json:"p90"`
+	P99 seri.Duration `json:"p99"`
+	Max seri.Duration `json:"max"`
+}
+
+// EndpointStat is completeness of results of an endpoint.
+type EndpointStat struct {
+	Name    string `json:"name"`
+	Results int    `json:"results"`
+
+	// Ratio is ratio of results to succeeded requests.
+	Ratio float64 `json:"ratio"`
+
+	// Marks is number of marks, for each mark.
+	Marks map[string]int `json:"marks,omitempty"`
+
+	// Missing is number of requests which has neither results nor marks
+	// after "settle". serinin doesn't record failures of endpoints, so most
+	// of them are timeouts of the endpoint.
+	Missing int `json:"missing"`
+}
+
+func newReport(cf *Config, samples []*sample, elapsed time.Duration) *Report {
+	rep := &Report{
+		Target:  cf.Target,
+		Elapsed: seri.Duration(elapsed),
+		Sent:    len(samples),
+	}
+	latencies := make([]time.Duration, 0, len(samples))
+	for _, s := range samples {
+		if s.Err != nil {
+			rep.Failed++
+			if s.Timeout {
+				rep.Timeouts++
+			}
+			continue
+		}
+		if s.Degraded {
+			rep.Degraded++
+		}
+		latencies = append(latencies, s.Latency)
+	}
+	if elapsed > 0 {
+		rep.QPS = float64(len(samples)) / elapsed.Seconds()
+	}
+	rep.Latency = newPercentiles(latencies)
+	return rep
+}
+
+func newPercentiles(d []time.Duration) Percentiles {
+	if len(d) == 0 {
+		return Percentiles{}
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	at := func(p float64) seri.Duration {
+		n := int(float64(len(d))*p+0.5) - 1
+		if n < 0 {
+			n = 0
+		}
+		return seri.Duration(d[n])
+	}
+	return Percentiles{
+		Min: seri.Duration(d[0]),
+		P50: at(0.50),
+		P90: at(0.90),
+		P99: at(0.99),
+		Max: seri.Duration(d[len(d)-1]),
+	}
+}
+
+// addResults counts results of succeeded requests, for each endpoint.
+func (rep *Report) addResults(endpoints []string, responses map[string]*seri.Response) {
+	rep.Verified = true
+	succeeded := rep.Sent - rep.Failed
+	for _, name := range endpoints {
+		rep.Endpoints = append(rep.Endpoints, &EndpointStat{Name: name, Missing: succeeded})
+	}
+	for _, r := range responses {
+		complete := true
+		for _, es := range rep.Endpoints {
+			if _, ok := r.Results[es.Name]; ok {
+				es.Results++
+				es.Missing--
+				continue
+			}
+			if m, ok := r.Marks[es.Name]; ok {
+				if es.Marks == nil {
+					es.Marks = map[string]int{}
+				}
+				es.Marks[m]++
+				es.Missing--
+				continue
+			}
+			complete = false
+		}
+		if complete {
+			rep.Complete++
+		}
+	}
+	if succeeded > 0 {
+		for _, es := range rep.Endpoints {
+			es.Ratio = float64(es.Results) / float64(succeeded)
+		}
+	}
+}
+
+// WriteJSON writes the report as JSON.
+func (rep *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+// WriteText writes the report in human readable format.
+func (rep *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	succeeded := rep.Sent - rep.Failed
+	fmt.Fprintf(tw, "target:\t%s\n", rep.Target)
+	fmt.Fprintf(tw, "requests:\tsent=%d succeeded=%d failed=%d (timeouts=%d) degraded=%d\n",
+		rep.Sent, succeeded, rep.Failed, rep.Timeouts, rep.Degraded)
+	fmt.Fprintf(tw, "throughput:\t%.1f req/s in %s\n",
+		rep.QPS, time.Duration(rep.Elapsed).Round(time.Millisecond))
+	l := rep.Latency
+	fmt.Fprintf(tw, "latency:\tmin=%s p50=%s p90=%s p99=%s max=%s\n",
+		round(l.Min), round(l.P50), round(l.P90), round(l.P99), round(l.Max))
+	if !rep.Verified {
+		return tw.Flush()
+	}
+	fmt.Fprintf(tw, "complete:\t%d/%d (%.1f%%)\n", rep.Complete, succeeded, percent(rep.Complete, succeeded))
+	fmt.Fprintf(tw, "\nendpoint\tresults\tratio\tmissing\tmarks\n")
+	for _, es := range rep.Endpoints {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%d\t%s\n",
+			es.Name, es.Results, es.Ratio*100, es.Missing, formatMarks(es.Marks))
+	}
+	return tw.Flush()
+}
+
+func round(d seri.Duration) time.Duration {
+	return time.Duration(d).Round(10 * time.Microsecond)
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+func formatMarks(marks map[string]int) string {
+	names := make([]string, 0, len(marks))
+	for m := range marks {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	for i, m := range names {
+		names[i] = fmt.Sprintf("%s=%d", m, marks[m])
+	}
+	return strings.Join(names, " ")
+}
@@ -1,28 +1,42 @@
 module github.com/koron/serinin
 
-go 1.19
+go 1.22
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/andybalholm/brotli v1.0.5
 	github.com/bradfitz/gomemcache v0.0.0-20230124162541-5f7a7d875746
 	github.com/charithe/mnemosyne v0.0.1
 	github.com/go-redis/redis/v7 v7.4.1
 	github.com/google/uuid v1.6.0
+	github.com/klauspost/compress v1.18.0
 	github.com/koron-go/ctxsrv v1.0.2
 	github.com/koron-go/reqlim v0.1.0
 	github.com/koron-go/sigctx v1.1.1
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/tinylib/msgp v1.4.0
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/time v0.6.0
+	google.golang.org/protobuf v1.36.1
 )
 
 require (
 	github.com/Shopify/toxiproxy v2.1.4+incompatible // indirect
 	github.com/akutz/memconn v0.1.0 // indirect
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
 	github.com/dchest/siphash v1.2.1 // indirect
 	github.com/dgryski/go-jump v0.0.0-20170409065014-e1f439676b57 // indirect
 	github.com/eapache/go-resiliency v1.2.0 // indirect
-	github.com/google/go-cmp v0.5.9 // indirect
+	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/onsi/ginkgo v1.16.5 // indirect
 	github.com/onsi/gomega v1.27.1 // indirect
+	github.com/philhofer/fwd v1.2.0 // indirect
 	github.com/stretchr/testify v1.5.1 // indirect
+	github.com/yuin/gopher-lua v0.0.0-20220504180219-658193537a64 // indirect
 	go.opencensus.io v0.22.4 // indirect
-	golang.org/x/sync v0.0.0-20201020160332-67f06af15bc9 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
 )
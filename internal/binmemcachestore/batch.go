@@ -0,0 +1,178 @@
+package binmemcachestore
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/charithe/mnemosyne/memcache"
+)
+
+// batcher stores values with quiet sets (SETQ). For each node, it writes all
+// sets of a batch followed by a NOOP at once, then reads responses until the
+// one of the NOOP. memcached responds to quiet sets only when they fail, so a
+// batch is stored in a round trip per node.
+//
+// mnemosyne uses quiet opcodes only for MultiGet and MultiDelete, so batcher
+// has its own connections. They are made by the same connector, and nodes
+// are picked by the same node picker as the client.
+type batcher struct {
+	connector memcache.Connector
+	picker    memcache.NodePicker
+	expiry    uint32
+	maxIdle   int
+
+	mu     sync.Mutex
+	idle   map[string][]net.Conn
+	closed bool
+}
+
+type setItem struct {
+	key   []byte
+	value []byte
+}
+
+func newBatcher(c memcache.Connector, p memcache.NodePicker, expiresIn time.Duration, maxIdle int) *batcher {
+	if maxIdle <= 0 {
+		maxIdle = 1
+	}
+	return &batcher{
+		connector: c,
+		picker:    p,
+		expiry:    uint32(expiresIn.Seconds()),
+		maxIdle:   maxIdle,
+		idle:      make(map[string][]net.Conn),
+	}
+}
+
+// set stores items. Items are grouped by nodes, and stored to the nodes in
+// parallel.
+func (b *batcher) set(ctx context.Context, items []setItem) error {
+	groups := make(map[string][]setItem)
+	for _, it := range items {
+		node := b.picker.Pick(it.key)
+		groups[node] = append(groups[node], it)
+	}
+	errCh := make(chan error, len(groups))
+	for node, g := range groups {
+		go func(node string, g []setItem) {
+			errCh <- b.setNode(ctx, node, g)
+		}(node, g)
+	}
+	var errs []error
+	for range groups {
+		if err := <-errCh; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setNode stores items to a node. A connection from the pool may be closed
+// by the server already, then it retries with a new connection. Sets are
+// idempotent, so it is safe to send them again.
+func (b *batcher) setNode(ctx context.Context, node string, items []setItem) error {
+	for {
+		conn, reused, err := b.get(node)
+		if err != nil {
+			return fmt.Errorf("%s: %w", node, err)
+		}
+		errs, err := b.roundTrip(ctx, conn, items)
+		if err != nil {
+			conn.Close()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if reused {
+				continue
+			}
+			return fmt.Errorf("%s: %w", node, err)
+		}
+		b.put(node, conn)
+		return errors.Join(errs...)
+	}
+}
+
+// roundTrip sends a batch of quiet sets and a NOOP, then reads responses.
+// It returns errors of each sets in errs, and I/O or protocol errors, which
+// break the connection, in err.
+func (b *batcher) roundTrip(ctx context.Context, conn net.Conn, items []setItem) (errs []error, err error) {
+	// clear the deadline set by the last cancellation.
+	conn.SetDeadline(time.Time{})
+	stop := context.AfterFunc(ctx, func() {
+		// interrupt blocked reads and writes.
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	var extras [8]byte
+	binary.BigEndian.PutUint32(extras[4:8], b.expiry)
+	var buf []byte
+	for i, it := range items {
+		buf = appendRequest(buf, opSetQ, uint32(i), it.key, extras[:], it.value)
+	}
+	buf = appendRequest(buf, opNoop, uint32(len(items)), nil, nil, nil)
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		hdr, body, err := readResponse(r)
+		if err != nil {
+			return nil, err
+		}
+		op, opaque := hdr[1], binary.BigEndian.Uint32(hdr[12:16])
+		if op == opNoop && opaque == uint32(len(items)) {
+			return errs, nil
+		}
+		if op != opSetQ || opaque >= uint32(len(items)) {
+			return nil, fmt.Errorf("unexpected response: opcode=%#x opaque=%d", op, opaque)
+		}
+		status := binary.BigEndian.Uint16(hdr[6:8])
+		errs = append(errs, fmt.Errorf("failed to set %s: status=%#x: %s", items[opaque].key, status, body))
+	}
+}
+
+// get returns an idle connection to the node, or a new one. reused reports
+// whether the connection is from the pool.
+func (b *batcher) get(node string) (conn net.Conn, reused bool, err error) {
+	b.mu.Lock()
+	if cs := b.idle[node]; len(cs) > 0 {
+		conn = cs[len(cs)-1]
+		b.idle[node] = cs[:len(cs)-1]
+		b.mu.Unlock()
+		return conn, true, nil
+	}
+	b.mu.Unlock()
+	conn, err = b.connector.Connect(node)
+	return conn, false, err
+}
+
+// put returns a connection to the pool, or closes it when the pool is full.
+func (b *batcher) put(node string, conn net.Conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || len(b.idle[node]) >= b.maxIdle {
+		conn.Close()
+		return
+	}
+	b.idle[node] = append(b.idle[node], conn)
+}
+
+func (b *batcher) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, cs := range b.idle {
+		for _, c := range cs {
+			c.Close()
+		}
+	}
+	b.idle = nil
+}
@@ -0,0 +1,166 @@
+package binmemcachestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charithe/mnemosyne/memcache"
+	"github.com/koron/serinin/internal/seri"
+)
+
+func newTestStore(t *testing.T, addrs ...string) *store {
+	t.Helper()
+	st, err := newStore(&seri.Memcache{
+		Addrs:    addrs,
+		ExpireIn: seri.Duration(time.Hour),
+	}, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func batchItems(reqids ...string) []seri.BatchItem {
+	var items []seri.BatchItem
+	for _, id := range reqids {
+		for _, name := range []string{"a", "b", "c"} {
+			items = append(items, seri.BatchItem{
+				ReqID: id,
+				Name:  name,
+				Data:  []byte(id + "/" + name),
+			})
+		}
+	}
+	return items
+}
+
+func TestStoreResponses(t *testing.T) {
+	servers := map[string]*server{}
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		s := newServer(t, 0)
+		servers[s.addr()] = s
+		addrs = append(addrs, s.addr())
+	}
+	st := newTestStore(t, addrs...)
+	var ids []string
+	for i := 0; i < 10; i++ {
+		ids = append(ids, fmt.Sprintf("req%d", i))
+	}
+	items := batchItems(ids...)
+	if err := st.StoreResponses(context.Background(), items); err != nil {
+		t.Fatal(err)
+	}
+
+	picker := memcache.NewSimpleNodePicker(addrs...)
+	sets := map[string]int{}
+	for _, it := range items {
+		key := it.ReqID + "." + it.Name
+		addr := picker.Pick([]byte(key))
+		sets[addr]++
+		v, expiry, ok := servers[addr].get(key)
+		if !ok {
+			t.Errorf("%s is not stored to %s", key, addr)
+			continue
+		}
+		if string(v) != string(it.Data) || expiry != 3600 {
+			t.Errorf("unexpected value of %s: value=%q expiry=%d", key, v, expiry)
+		}
+	}
+	for addr, s := range servers {
+		if sets[addr] == 0 {
+			t.Fatalf("no keys are picked for %s", addr)
+		}
+		// a batch is sent in a round trip: quiet sets and a NOOP.
+		if got := s.count(opSetQ); got != sets[addr] {
+			t.Errorf("%s: unexpected number of SETQ: want=%d got=%d", addr, sets[addr], got)
+		}
+		if got := s.count(opNoop); got != 1 {
+			t.Errorf("%s: unexpected number of NOOP: %d", addr, got)
+		}
+		if got := s.count(opSet); got != 0 {
+			t.Errorf("%s: SET is used: %d", addr, got)
+		}
+	}
+}
+
+func TestStoreResponsesError(t *testing.T) {
+	s := newServer(t, 10)
+	st := newTestStore(t, s.addr())
+	items := batchItems("r0")
+	items[1].Data = []byte(strings.Repeat("x", 11))
+	err := st.StoreResponses(context.Background(), items)
+	if err == nil || !strings.Contains(err.Error(), "r0.b") || !strings.Contains(err.Error(), "Too large.") {
+		t.Fatalf("error of a set is not reported: %v", err)
+	}
+	for _, key := range []string{"r0.a", "r0.c"} {
+		if _, _, ok := s.get(key); !ok {
+			t.Errorf("%s is not stored", key)
+		}
+	}
+
+	// the connection is still available.
+	if err := st.StoreResponses(context.Background(), batchItems("r1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.connCount(); got != 1 {
+		t.Errorf("connection is not reused: %d", got)
+	}
+}
+
+func TestStoreResponsesReconnect(t *testing.T) {
+	s := newServer(t, 0)
+	st := newTestStore(t, s.addr())
+	if err := st.StoreResponses(context.Background(), batchItems("r0")); err != nil {
+		t.Fatal(err)
+	}
+	// the idle connection is closed by the server.
+	s.closeConns()
+	if err := st.StoreResponses(context.Background(), batchItems("r1")); err != nil {
+		t.Fatalf("failed to store with a closed connection: %s", err)
+	}
+	if _, _, ok := s.get("r1.a"); !ok {
+		t.Error("r1.a is not stored")
+	}
+}
+
+func TestStoreResponsesCanceled(t *testing.T) {
+	// a server which never responds.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	st := newTestStore(t, ln.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- st.StoreResponses(ctx, batchItems("r0")) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StoreResponses is not canceled")
+	}
+}
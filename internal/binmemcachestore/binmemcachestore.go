@@ -2,9 +2,8 @@ package binmemcachestore
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"strings"
+	"fmt"
 	"time"
 
 	"github.com/charithe/mnemosyne/memcache"
@@ -13,11 +12,22 @@ import (
 
 type store struct {
 	client    *memcache.Client
+	batch     *batcher
+	addrs     []string
+	keys      *seri.Keys
 	expiresIn time.Duration
+	codec     string
 	ens       []string
 }
 
-var _ seri.Storage = (*store)(nil)
+var (
+	_ seri.Storage      = (*store)(nil)
+	_ seri.Marker       = (*store)(nil)
+	_ seri.ResultStorer = (*store)(nil)
+	_ seri.Pinger       = (*store)(nil)
+	_ seri.Deleter      = (*store)(nil)
+	_ seri.BatchStorer  = (*store)(nil)
+)
 
 func newStore(cfg *seri.Memcache, ens []string) (*store, error) {
 	if cfg == nil {
@@ -26,8 +36,33 @@ func newStore(cfg *seri.Memcache, ens []string) (*store, error) {
 	if len(cfg.Addrs) == 0 {
 		return nil, errors.New("\"addrs\" requires one or more addresses")
 	}
+	if err := seri.CheckCodec(cfg.Codec); err != nil {
+		return nil, err
+	}
+	keys, err := seri.NewKeys(cfg.KeyPrefix, cfg.KeyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	picker := memcache.NewSimpleNodePicker(cfg.Addrs...)
+	conn := memcache.NewSimpleTCPConnector()
+	if cfg.TLS != nil || cfg.Username != "" {
+		c := &connector{
+			timeout:  time.Second,
+			username: cfg.Username,
+			password: cfg.Password,
+		}
+		if cfg.TLS != nil {
+			tc, err := cfg.TLS.Config()
+			if err != nil {
+				return nil, err
+			}
+			c.tls = tc
+		}
+		conn = c
+	}
 	client, err := memcache.NewClient(
-		memcache.WithNodePicker(memcache.NewSimpleNodePicker(cfg.Addrs...)),
+		memcache.WithNodePicker(picker),
+		memcache.WithConnector(conn),
 		memcache.WithConnectionsPerNode(cfg.ConnsPerNode),
 	)
 	if err != nil {
@@ -35,72 +70,173 @@ func newStore(cfg *seri.Memcache, ens []string) (*store, error) {
 	}
 	return &store{
 		client:    client,
+		batch:     newBatcher(conn, picker, time.Duration(cfg.ExpireIn), cfg.ConnsPerNode),
+		addrs:     cfg.Addrs,
+		keys:      keys,
 		expiresIn: time.Duration(cfg.ExpireIn),
+		codec:     cfg.Codec,
 		ens:       ens,
 	}, nil
 }
 
-func (mbs *store) StoreRequest(reqid, method, url string) error {
-	b, err := json.Marshal(&seri.Response{
-		ID:     reqid,
-		Method: method,
-		URL:    url,
-	})
+func (mbs *store) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	b, err := seri.EncodeRequest(mbs.codec, reqid, method, url)
 	if err != nil {
 		return err
 	}
-	_, err = mbs.client.Set(context.Background(), []byte(reqid), b, memcache.WithExpiry(mbs.expiresIn))
+	_, err = mbs.client.Set(ctx, []byte(mbs.keys.Request(reqid)), b, memcache.WithExpiry(mbs.expiresIn))
 	return err
 }
 
-func (mbs *store) StoreResponse(reqid, name string, data []byte) error {
-	_, err := mbs.client.Set(context.Background(), []byte(reqid+"."+name), data, memcache.WithExpiry(mbs.expiresIn))
-	return err
+func (mbs *store) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return mbs.StoreResult(ctx, reqid, name, data, seri.ResultInfo{})
 }
 
-func (mbs *store) extractName(key string, reqid string) string {
-	if !strings.HasPrefix(key, reqid) {
-		return ""
+// StoreResult stores a result. Status and latency are stored only with
+// "codec".
+func (mbs *store) StoreResult(ctx context.Context, reqid, name string, data []byte, info seri.ResultInfo) error {
+	v, err := seri.EncodeResult(mbs.codec, data, info)
+	if err != nil {
+		return err
 	}
-	if len(key) <= len(reqid) || key[len(reqid)] != '.' {
-		return ""
+	_, err = mbs.client.Set(ctx, []byte(mbs.keys.Result(reqid, name)), v, memcache.WithExpiry(mbs.expiresIn))
+	return err
+}
+
+// StoreResponses stores results of a batch with quiet sets, in a round trip
+// per node. It isn't transactional, so some results may be stored even if it
+// fails.
+func (mbs *store) StoreResponses(ctx context.Context, items []seri.BatchItem) error {
+	sets := make([]setItem, len(items))
+	for i, it := range items {
+		v, err := seri.EncodeResult(mbs.codec, it.Data, it.Info)
+		if err != nil {
+			return err
+		}
+		sets[i] = setItem{key: []byte(mbs.keys.Result(it.ReqID, it.Name)), value: v}
 	}
-	return key[len(reqid)+1:]
+	return mbs.batch.set(ctx, sets)
+}
+
+func (mbs *store) MarkResponse(ctx context.Context, reqid, name, mark string) error {
+	_, err := mbs.client.Set(ctx, []byte(mbs.keys.Mark(reqid, name)), []byte(mark), memcache.WithExpiry(mbs.expiresIn))
+	return err
 }
 
-func (mbs *store) GetResponse(reqid string) (*seri.Response, error) {
-	keys := make([][]byte, 1, len(mbs.ens))
-	keys[0] = []byte(reqid)
+func (mbs *store) GetResponse(ctx context.Context, reqid string) (*seri.Response, error) {
+	rkey := mbs.keys.Request(reqid)
+	keys := make([][]byte, 1, len(mbs.ens)*2+1)
+	keys[0] = []byte(rkey)
+	// results and marks are mapped from keys to names of endpoints.
+	results := make(map[string]string, len(mbs.ens))
+	marks := make(map[string]string, len(mbs.ens))
 	for _, en := range mbs.ens {
-		keys = append(keys, []byte(reqid+"."+en))
+		k1, k2 := mbs.keys.Result(reqid, en), mbs.keys.Mark(reqid, en)
+		keys = append(keys, []byte(k1), []byte(k2))
+		results[k1] = en
+		marks[k2] = en
 	}
-	rs, err := mbs.client.MultiGet(context.Background(), keys...)
-	if err != nil {
+	rs, err := mbs.client.MultiGet(ctx, keys...)
+	// the last key for each node is got with GETK, so its miss is reported
+	// as an error.
+	if _, err := countNotFound(err); err != nil {
 		return nil, err
 	}
 
 	resp := new(seri.Response)
-	resp.Results = make(map[string]string)
+	resp.Results = make(map[string]*seri.Result)
 	for _, r := range rs {
 		if err := r.Err(); err != nil {
 			continue
 		}
 		k := string(r.Key())
-		if k == reqid {
-			err := json.Unmarshal(r.Value(), resp)
+		if k == rkey {
+			r0, err := seri.DecodeRequest(r.Value())
 			if err != nil {
 				return nil, err
 			}
+			resp.ID, resp.Method, resp.URL = r0.ID, r0.Method, r0.URL
+			continue
+		}
+		if name, ok := marks[k]; ok {
+			if resp.Marks == nil {
+				resp.Marks = make(map[string]string)
+			}
+			resp.Marks[name] = string(r.Value())
 			continue
 		}
-		if name := mbs.extractName(k, reqid); name != "" {
-			resp.Results[name] = string(r.Value())
+		if name, ok := results[k]; ok {
+			d, info, err := seri.DecodeResult(r.Value())
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode result of %s: %w", name, err)
+			}
+			resp.Results[name] = &seri.Result{Data: d, ContentType: info.ContentType}
 		}
 	}
+	if resp.ID == "" && len(resp.Results) == 0 && len(resp.Marks) == 0 {
+		return nil, fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+	}
 
 	return resp, nil
 }
 
+// DeleteRequest deletes keys of a request, its results and marks.
+func (mbs *store) DeleteRequest(ctx context.Context, reqid string) error {
+	keys := make([][]byte, 1, len(mbs.ens)*2+1)
+	keys[0] = []byte(mbs.keys.Request(reqid))
+	for _, en := range mbs.ens {
+		keys = append(keys, []byte(mbs.keys.Result(reqid, en)), []byte(mbs.keys.Mark(reqid, en)))
+	}
+	n, err := countNotFound(mbs.client.MultiDelete(ctx, keys...))
+	if err != nil {
+		return err
+	}
+	if n == len(keys) {
+		return fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+	}
+	return nil
+}
+
+// countNotFound counts "key not found" errors in err of an operation. Errors
+// of a batch operation are nested for each nodes. It returns other errors as
+// is.
+func countNotFound(err error) (int, error) {
+	var me *memcache.Error
+	if !errors.As(err, &me) || len(me.Errors()) == 0 {
+		var ke *memcache.KeyError
+		if errors.As(err, &ke) && ke.Err == memcache.ErrKeyNotFound {
+			return 1, nil
+		}
+		return 0, err
+	}
+	n := 0
+	var errs []error
+	for _, e := range me.Errors() {
+		m, err := countNotFound(e)
+		n += m
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return n, errors.Join(errs...)
+}
+
+// Ping checks all memcached servers are available, with "stat" command.
+func (mbs *store) Ping(ctx context.Context) error {
+	for _, addr := range mbs.addrs {
+		if _, err := mbs.client.Stats(ctx, addr, ""); err != nil {
+			return fmt.Errorf("%s: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+// Close closes connections to memcached servers.
+func (mbs *store) Close() error {
+	mbs.batch.close()
+	return mbs.client.Close()
+}
+
 func init() {
 	seri.RegisterStorage("binmemcache", func(cfg *seri.Config) (seri.Storage, error) {
 		return newStore(cfg.BinMemcache, cfg.EntryPointNames())
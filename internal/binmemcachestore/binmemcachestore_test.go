@@ -0,0 +1,79 @@
+package binmemcachestore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+func TestGetResponse(t *testing.T) {
+	st := newTestStore(t, newServer(t, 0).addr(), newServer(t, 0).addr())
+	ctx := context.Background()
+	if err := st.StoreRequest(ctx, "r1", "GET", "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.StoreResponse(ctx, "r1", "a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// other results and marks are missing.
+	r, err := st.GetResponse(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != "r1" || r.URL != "/foo" || len(r.Results) != 1 || string(r.Results["a"].Data) != "hello" || r.Marks != nil {
+		t.Errorf("unexpected response: %+v", r)
+	}
+
+	if _, err := st.GetResponse(ctx, "r2"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error for an unknown request: %v", err)
+	}
+}
+
+func TestDeleteRequest(t *testing.T) {
+	servers := []*server{newServer(t, 0), newServer(t, 0)}
+	st := newTestStore(t, servers[0].addr(), servers[1].addr())
+	ctx := context.Background()
+	if err := st.StoreRequest(ctx, "r1", "GET", "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.StoreResponse(ctx, "r1", "a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MarkResponse(ctx, "r1", "b", seri.MarkAborted); err != nil {
+		t.Fatal(err)
+	}
+
+	// some of keys are missing.
+	if err := st.DeleteRequest(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range servers {
+		for _, k := range []string{"r1", "r1.a", "r1._mark.b"} {
+			if _, _, ok := s.get(k); ok {
+				t.Errorf("%s is not deleted", k)
+			}
+		}
+	}
+	// all keys are missing.
+	if err := st.DeleteRequest(ctx, "r1"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error for a deleted request: %v", err)
+	}
+	// only a result is left.
+	if err := st.StoreResponse(ctx, "r2", "c", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.DeleteRequest(ctx, "r2"); err != nil {
+		t.Errorf("failed to delete a result: %s", err)
+	}
+
+	// other errors than misses are reported.
+	for _, s := range servers {
+		s.setBusy(true)
+	}
+	err := st.DeleteRequest(ctx, "r3")
+	if err == nil || errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
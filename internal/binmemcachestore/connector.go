@@ -0,0 +1,107 @@
+package binmemcachestore
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/charithe/mnemosyne/memcache"
+)
+
+// connector connects to memcached nodes with TLS and/or SASL authentication.
+type connector struct {
+	timeout  time.Duration
+	tls      *tls.Config
+	username string
+	password string
+}
+
+var _ memcache.Connector = (*connector)(nil)
+
+func (c *connector) Connect(nodeID string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: c.timeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if c.tls != nil {
+		conn, err = tls.DialWithDialer(d, "tcp", nodeID, c.tls)
+	} else {
+		conn, err = d.Dial("tcp", nodeID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.username != "" {
+		err := c.auth(conn)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("SASL authentication failed for %s: %w", nodeID, err)
+		}
+	}
+	return conn, nil
+}
+
+const (
+	magicRequest  = 0x80
+	magicResponse = 0x81
+
+	opNoop     = 0x0a
+	opSetQ     = 0x11
+	opSASLAuth = 0x21
+)
+
+// auth authenticates with SASL PLAIN mechanism of binary protocol.
+func (c *connector) auth(conn net.Conn) error {
+	if c.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	value := "\x00" + c.username + "\x00" + c.password
+	if _, err := conn.Write(appendRequest(nil, opSASLAuth, 0, []byte("PLAIN"), nil, []byte(value))); err != nil {
+		return err
+	}
+	hdr, body, err := readResponse(conn)
+	if err != nil {
+		return err
+	}
+	if status := binary.BigEndian.Uint16(hdr[6:8]); status != 0 {
+		return fmt.Errorf("status=%#x: %s", status, body)
+	}
+	return nil
+}
+
+// appendRequest appends a request packet of binary protocol to b.
+func appendRequest(b []byte, op byte, opaque uint32, key, extras, value []byte) []byte {
+	var hdr [24]byte
+	hdr[0] = magicRequest
+	hdr[1] = op
+	binary.BigEndian.PutUint16(hdr[2:4], uint16(len(key)))
+	hdr[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(hdr[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(hdr[12:16], opaque)
+	b = append(b, hdr[:]...)
+	b = append(b, extras...)
+	b = append(b, key...)
+	return append(b, value...)
+}
+
+// readResponse reads a response packet of binary protocol, and returns its
+// header and body.
+func readResponse(r io.Reader) (hdr, body []byte, err error) {
+	hdr = make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, nil, err
+	}
+	if hdr[0] != magicResponse {
+		return nil, nil, fmt.Errorf("unexpected magic: %#x", hdr[0])
+	}
+	body = make([]byte, binary.BigEndian.Uint32(hdr[8:12]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	return hdr, body, nil
+}
@@ -0,0 +1,157 @@
+package binmemcachestore
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+	"github.com/koron/serinin/internal/tlstest"
+)
+
+// saslServer is a stand-in of memcached which only accepts SASL PLAIN
+// authentication of binary protocol.
+type saslServer struct {
+	ln       net.Listener
+	username string
+	password string
+
+	// states receives TLS states of accepted connections.
+	states chan tls.ConnectionState
+	// mechs receives mechanisms of authentication requests.
+	mechs chan string
+}
+
+func newSASLServer(t *testing.T, tc *tls.Config, username, password string) *saslServer {
+	t.Helper()
+	var (
+		ln  net.Listener
+		err error
+	)
+	if tc != nil {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", tc)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &saslServer{
+		ln:       ln,
+		username: username,
+		password: password,
+		states:   make(chan tls.ConnectionState, 1),
+		mechs:    make(chan string, 1),
+	}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *saslServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *saslServer) handle(conn net.Conn) {
+	defer conn.Close()
+	if tc, ok := conn.(*tls.Conn); ok {
+		if tc.Handshake() != nil {
+			return
+		}
+		s.states <- tc.ConnectionState()
+	}
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return
+	}
+	keyLen := binary.BigEndian.Uint16(hdr[2:4])
+	body := make([]byte, binary.BigEndian.Uint32(hdr[8:12]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return
+	}
+	mech := string(body[:keyLen])
+	s.mechs <- mech
+	status, msg := uint16(0), "Authenticated."
+	if hdr[0] != magicRequest || hdr[1] != opSASLAuth || mech != "PLAIN" ||
+		string(body[keyLen:]) != "\x00"+s.username+"\x00"+s.password {
+		status, msg = 0x20, "Auth failure."
+	}
+	resp := make([]byte, 24, 24+len(msg))
+	resp[0] = magicResponse
+	resp[1] = opSASLAuth
+	binary.BigEndian.PutUint16(resp[6:8], status)
+	binary.BigEndian.PutUint32(resp[8:12], uint32(len(msg)))
+	conn.Write(append(resp, msg...))
+	// keep the connection until the client closes it.
+	io.Copy(io.Discard, conn)
+}
+
+func TestConnectorSASL(t *testing.T) {
+	s := newSASLServer(t, nil, "user", "secret")
+	c := &connector{timeout: time.Second, username: "user", password: "secret"}
+	conn, err := c.Connect(s.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	conn.Close()
+	if mech := <-s.mechs; mech != "PLAIN" {
+		t.Errorf("unexpected mechanism: %s", mech)
+	}
+
+	c.password = "wrong"
+	if _, err := c.Connect(s.ln.Addr().String()); err == nil {
+		t.Fatal("connect should fail with wrong password")
+	}
+}
+
+func TestConnectorTLS(t *testing.T) {
+	certs := tlstest.New(t)
+	s := newSASLServer(t, certs.ServerConfig(true), "user", "secret")
+	tc, err := (&seri.TLS{
+		CAFile:     certs.CAFile,
+		CertFile:   certs.ClientCertFile,
+		KeyFile:    certs.ClientKeyFile,
+		ServerName: tlstest.ServerName,
+	}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &connector{timeout: time.Second, tls: tc, username: "user", password: "secret"}
+	conn, err := c.Connect(s.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("connect failed: %s", err)
+	}
+	conn.Close()
+	st := <-s.states
+	if st.ServerName != tlstest.ServerName {
+		t.Errorf("unexpected server name: want=%s got=%s", tlstest.ServerName, st.ServerName)
+	}
+	if len(st.PeerCertificates) == 0 || st.PeerCertificates[0].Subject.CommonName != "client" {
+		t.Error("client certificate is not presented")
+	}
+	if mech := <-s.mechs; mech != "PLAIN" {
+		t.Errorf("unexpected mechanism: %s", mech)
+	}
+}
+
+func TestConnectorTLSServerName(t *testing.T) {
+	certs := tlstest.New(t)
+	s := newSASLServer(t, certs.ServerConfig(false), "user", "secret")
+	tc, err := (&seri.TLS{CAFile: certs.CAFile, ServerName: "other.test"}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &connector{timeout: time.Second, tls: tc}
+	if _, err := c.Connect(s.ln.Addr().String()); err == nil {
+		t.Fatal("connect should fail with wrong server_name")
+	}
+}
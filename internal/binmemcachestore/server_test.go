@@ -0,0 +1,230 @@
+package binmemcachestore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+const (
+	opGet     = 0x00
+	opSet     = 0x01
+	opDelete  = 0x04
+	opGetQ    = 0x09
+	opGetK    = 0x0c
+	opGetKQ   = 0x0d
+	opStat    = 0x10
+	opDeleteQ = 0x14
+
+	statusKeyNotFound   = 0x01
+	statusValueTooLarge = 0x03
+	statusUnknown       = 0x81
+	statusBusy          = 0x85
+)
+
+// server is a stand-in of memcached with binary protocol, which keeps values
+// in a map.
+type server struct {
+	ln       net.Listener
+	maxValue int
+
+	mu      sync.Mutex
+	busy    bool
+	values  map[string][]byte
+	expiry  map[string]uint32
+	ops     []byte
+	conns   int
+	clients []net.Conn
+}
+
+// newServer starts a server. It rejects values larger than maxValue, when
+// maxValue is positive.
+func newServer(t *testing.T, maxValue int) *server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		ln:       ln,
+		maxValue: maxValue,
+		values:   make(map[string][]byte),
+		expiry:   make(map[string]uint32),
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.closeConns()
+	})
+	return s
+}
+
+func (s *server) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.clients = append(s.clients, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// closeConns closes all connections from clients.
+func (s *server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		c.Close()
+	}
+	s.clients = nil
+}
+
+func (s *server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		hdr := make([]byte, 24)
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(hdr[8:12]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		op := hdr[1]
+		extLen := int(hdr[4])
+		keyLen := int(binary.BigEndian.Uint16(hdr[2:4]))
+		extras := body[:extLen]
+		key := string(body[extLen : extLen+keyLen])
+		value := body[extLen+keyLen:]
+		resp := s.exec(op, extras, key, value)
+		if resp == nil {
+			continue
+		}
+		copy(resp[12:16], hdr[12:16])
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// exec executes a request, and returns a response packet. It returns nil
+// when a quiet request succeeds.
+func (s *server) exec(op byte, extras []byte, key string, value []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops = append(s.ops, op)
+	quiet := false
+	switch op {
+	case opSetQ:
+		quiet = true
+		fallthrough
+	case opSet:
+		if s.maxValue > 0 && len(value) > s.maxValue {
+			return response(op, statusValueTooLarge, nil, "", []byte("Too large."))
+		}
+		s.values[key] = append([]byte(nil), value...)
+		s.expiry[key] = binary.BigEndian.Uint32(extras[4:8])
+		if quiet {
+			return nil
+		}
+		return response(op, 0, nil, "", nil)
+	case opGetQ, opGetKQ:
+		quiet = true
+		fallthrough
+	case opGet, opGetK:
+		v, ok := s.values[key]
+		if !ok {
+			if quiet {
+				return nil
+			}
+			return response(op, statusKeyNotFound, nil, "", []byte("Not found"))
+		}
+		if op == opGet || op == opGetQ {
+			key = ""
+		}
+		return response(op, 0, make([]byte, 4), key, v)
+	case opDeleteQ:
+		quiet = true
+		fallthrough
+	case opDelete:
+		if s.busy {
+			return response(op, statusBusy, nil, "", []byte("Busy"))
+		}
+		if _, ok := s.values[key]; !ok {
+			return response(op, statusKeyNotFound, nil, "", []byte("Not found"))
+		}
+		delete(s.values, key)
+		if quiet {
+			return nil
+		}
+		return response(op, 0, nil, "", nil)
+	case opNoop, opStat:
+		return response(op, 0, nil, "", nil)
+	default:
+		return response(op, statusUnknown, nil, "", []byte("Unknown command"))
+	}
+}
+
+func response(op byte, status uint16, extras []byte, key string, value []byte) []byte {
+	b := make([]byte, 24, 24+len(extras)+len(key)+len(value))
+	b[0] = magicResponse
+	b[1] = op
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(key)))
+	b[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(b[6:8], status)
+	binary.BigEndian.PutUint32(b[8:12], uint32(len(extras)+len(key)+len(value)))
+	b = append(b, extras...)
+	b = append(b, key...)
+	return append(b, value...)
+}
+
+// count returns number of requests of op.
+func (s *server) count(op byte) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, o := range s.ops {
+		if o == op {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *server) resetOps() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops = nil
+}
+
+// setBusy makes deletes fail.
+func (s *server) setBusy(busy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy = busy
+}
+
+func (s *server) connCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func (s *server) get(key string) ([]byte, uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, s.expiry[key], ok
+}
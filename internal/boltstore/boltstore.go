@@ -0,0 +1,332 @@
+/*
+Package boltstore provides a store which persists responses to a local file
+with bbolt, an embedded key/value database.
+*/
+package boltstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// requestsBucket has a nested bucket for each requests. A nested bucket
+	// has keys same as fields of a hash of redis store.
+	requestsBucket = []byte("requests")
+
+	// expiresBucket maps request IDs to their expiration time.
+	expiresBucket = []byte("expires")
+
+	// expiryBucket is an index of expiration: keys are expiration time
+	// followed by a request ID, to be scanned in order of time by compaction.
+	expiryBucket = []byte("expiry")
+
+	// createdBucket maps request IDs to their creation time.
+	createdBucket = []byte("created")
+
+	// indexBucket is an index of creation: keys are creation time followed
+	// by a request ID, to list requests in order of time.
+	indexBucket = []byte("index")
+)
+
+type storage struct {
+	db        *bolt.DB
+	expiresIn time.Duration
+	codec     string
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+var (
+	_ seri.Storage      = (*storage)(nil)
+	_ seri.Marker       = (*storage)(nil)
+	_ seri.BatchStorer  = (*storage)(nil)
+	_ seri.ResultStorer = (*storage)(nil)
+	_ seri.Pinger       = (*storage)(nil)
+	_ seri.Lister       = (*storage)(nil)
+	_ seri.Deleter      = (*storage)(nil)
+)
+
+func newStorage(cfg *seri.Bolt) (*storage, error) {
+	if cfg == nil {
+		return nil, errors.New("\"bolt\" configuration is not available")
+	}
+	if cfg.Path == "" {
+		return nil, errors.New("\"path\" of \"bolt\" is required")
+	}
+	if err := seri.CheckCodec(cfg.Codec); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{
+		Timeout: time.Second,
+		NoSync:  cfg.NoSync,
+	})
+	if errors.Is(err, bolt.ErrTimeout) {
+		// the file is locked exclusively by another process, even when it
+		// is opened read-only (a shared lock).
+		return nil, fmt.Errorf("failed to open %s: %w: it is used by another process (serinin?), read responses with \"result_api\" while it is running", cfg.Path, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", cfg.Path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{requestsBucket, expiresBucket, expiryBucket, createdBucket, indexBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	bs := &storage{
+		db:        db,
+		expiresIn: time.Duration(cfg.ExpireIn),
+		codec:     cfg.Codec,
+		done:      make(chan struct{}),
+	}
+	if bs.expiresIn > 0 {
+		interval := time.Duration(cfg.CompactInterval)
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		bs.wg.Add(1)
+		go bs.compactLoop(interval)
+	}
+	return bs, nil
+}
+
+func (bs *storage) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return bs.db.Batch(func(tx *bolt.Tx) error {
+		b, err := bs.requestBucket(tx, reqid, time.Now())
+		if err != nil {
+			return err
+		}
+		for k, v := range map[string]string{
+			"_id":     reqid,
+			"_method": method,
+			"_url":    url,
+		} {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *storage) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return bs.StoreResult(ctx, reqid, name, data, seri.ResultInfo{})
+}
+
+// StoreResult stores a result. Status, latency and content type are stored
+// only with "codec".
+func (bs *storage) StoreResult(ctx context.Context, reqid, name string, data []byte, info seri.ResultInfo) error {
+	return bs.StoreResponses(ctx, []seri.BatchItem{{ReqID: reqid, Name: name, Data: data, Info: info}})
+}
+
+// StoreResponses stores results of a batch in a transaction.
+func (bs *storage) StoreResponses(ctx context.Context, items []seri.BatchItem) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return bs.db.Batch(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for _, it := range items {
+			b, err := bs.requestBucket(tx, it.ReqID, now)
+			if err != nil {
+				return err
+			}
+			v, err := seri.EncodeResult(bs.codec, it.Data, it.Info)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(it.Name), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *storage) MarkResponse(ctx context.Context, reqid, name, mark string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return bs.db.Batch(func(tx *bolt.Tx) error {
+		b, err := bs.requestBucket(tx, reqid, time.Now())
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(seri.MarkPrefix+name), []byte(mark))
+	})
+}
+
+// requestBucket returns a bucket for a request. When the bucket doesn't
+// exist, it creates the bucket and registers creation and expiration of it.
+func (bs *storage) requestBucket(tx *bolt.Tx, reqid string, now time.Time) (*bolt.Bucket, error) {
+	rb := tx.Bucket(requestsBucket)
+	if b := rb.Bucket([]byte(reqid)); b != nil {
+		return b, nil
+	}
+	b, err := rb.CreateBucket([]byte(reqid))
+	if err != nil {
+		return nil, err
+	}
+	ct := make([]byte, 8)
+	binary.BigEndian.PutUint64(ct, uint64(now.UnixNano()))
+	if err := tx.Bucket(createdBucket).Put([]byte(reqid), ct); err != nil {
+		return nil, err
+	}
+	if err := tx.Bucket(indexBucket).Put(append(ct, reqid...), nil); err != nil {
+		return nil, err
+	}
+	if bs.expiresIn <= 0 {
+		return b, nil
+	}
+	at := make([]byte, 8)
+	binary.BigEndian.PutUint64(at, uint64(now.Add(bs.expiresIn).UnixNano()))
+	if err := tx.Bucket(expiresBucket).Put([]byte(reqid), at); err != nil {
+		return nil, err
+	}
+	if err := tx.Bucket(expiryBucket).Put(append(at, reqid...), nil); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (bs *storage) GetResponse(ctx context.Context, reqid string) (*seri.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var r *seri.Response
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(requestsBucket).Bucket([]byte(reqid))
+		if b == nil || expired(tx, reqid, time.Now()) {
+			return fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+		}
+		r = &seri.Response{
+			ID:      string(b.Get([]byte("_id"))),
+			Method:  string(b.Get([]byte("_method"))),
+			URL:     string(b.Get([]byte("_url"))),
+			Results: make(map[string]*seri.Result),
+		}
+		return b.ForEach(func(k, v []byte) error {
+			name := string(k)
+			if strings.HasPrefix(name, seri.MarkPrefix) {
+				if r.Marks == nil {
+					r.Marks = make(map[string]string)
+				}
+				r.Marks[name[len(seri.MarkPrefix):]] = string(v)
+				return nil
+			}
+			if seri.IsReservedName(name) {
+				return nil
+			}
+			d, info, err := seri.DecodeResult(v)
+			if err != nil {
+				return fmt.Errorf("failed to decode result of %s: %w", name, err)
+			}
+			// copy a value, because it is valid only in the transaction.
+			r.Results[name] = &seri.Result{
+				Data:        append([]byte(nil), d...),
+				ContentType: info.ContentType,
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// DeleteRequest deletes a request, and removes it from indexes. It doesn't
+// use Batch, because an error of a function rolls back whole of a batch.
+func (bs *storage) DeleteRequest(ctx context.Context, reqid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		rb := tx.Bucket(requestsBucket)
+		if rb.Bucket([]byte(reqid)) == nil {
+			return fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+		}
+		if err := rb.DeleteBucket([]byte(reqid)); err != nil {
+			return err
+		}
+		return deleteIndexes(tx, []byte(reqid))
+	})
+}
+
+// deleteIndexes removes a request from buckets of expiration and creation.
+func deleteIndexes(tx *bolt.Tx, reqid []byte) error {
+	for _, names := range [][2][]byte{
+		{expiresBucket, expiryBucket},
+		{createdBucket, indexBucket},
+	} {
+		b := tx.Bucket(names[0])
+		at := b.Get(reqid)
+		if at == nil {
+			continue
+		}
+		if err := tx.Bucket(names[1]).Delete(append(append([]byte(nil), at...), reqid...)); err != nil {
+			return err
+		}
+		if err := b.Delete(reqid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expired checks a request is expired, but not compacted yet.
+func expired(tx *bolt.Tx, reqid string, now time.Time) bool {
+	at := tx.Bucket(expiresBucket).Get([]byte(reqid))
+	if len(at) != 8 {
+		return false
+	}
+	return int64(binary.BigEndian.Uint64(at)) <= now.UnixNano()
+}
+
+// Ping checks the database is available.
+func (bs *storage) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return bs.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+// Close stops compaction and closes the database.
+func (bs *storage) Close() error {
+	var err error
+	bs.closeOnce.Do(func() {
+		close(bs.done)
+		bs.wg.Wait()
+		err = bs.db.Close()
+	})
+	return err
+}
+
+func init() {
+	seri.RegisterStorage("bolt", func(cfg *seri.Config) (seri.Storage, error) {
+		return newStorage(cfg.Bolt)
+	})
+}
@@ -0,0 +1,332 @@
+package boltstore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestOpenLocked(t *testing.T) {
+	cfg := &seri.Bolt{Path: filepath.Join(t.TempDir(), "serinin.db")}
+	bs, err := newStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+
+	_, err = newStorage(cfg)
+	if err == nil {
+		t.Fatal("locked database should not be opened")
+	}
+	if !errors.Is(err, bolt.ErrTimeout) || !strings.Contains(err.Error(), "result_api") {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// a reader is available after serinin stops.
+	if err := bs.StoreRequest(context.Background(), "r1", "GET", "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	bs.Close()
+	bs2, err := newStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs2.Close()
+	r, err := bs2.GetResponse(context.Background(), "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != "r1" || r.URL != "/foo" {
+		t.Errorf("unexpected response: %+v", r)
+	}
+}
+
+func TestCodec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serinin.db")
+	ctx := context.Background()
+	info := seri.ResultInfo{Status: 200, Latency: time.Second, ContentType: "text/plain"}
+	// values are written with each codecs, to be read with any codecs.
+	for i, codec := range []string{"", "json", "msgpack", "protobuf"} {
+		bs, err := newStorage(&seri.Bolt{Path: path, Codec: codec})
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := "ep" + strconv.Itoa(i)
+		if i == 0 {
+			err = bs.StoreRequest(ctx, "r1", "GET", "/foo")
+		}
+		if err == nil {
+			err = bs.StoreResult(ctx, "r1", name, []byte("hello "+name), info)
+		}
+		bs.Close()
+		if err != nil {
+			t.Fatalf("%q: %s", codec, err)
+		}
+	}
+
+	bs, err := newStorage(&seri.Bolt{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	r, err := bs.GetResponse(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		name := "ep" + strconv.Itoa(i)
+		got := r.Results[name]
+		if got == nil || string(got.Data) != "hello "+name {
+			t.Errorf("unexpected result of %s: %+v", name, got)
+			continue
+		}
+		// status and content type are stored only with codecs.
+		if want := i > 0; (got.ContentType == "text/plain") != want {
+			t.Errorf("unexpected content type of %s: %q", name, got.ContentType)
+		}
+	}
+}
+
+func newTestStorage(t *testing.T, cfg *seri.Bolt) *storage {
+	t.Helper()
+	if cfg.Path == "" {
+		cfg.Path = filepath.Join(t.TempDir(), "serinin.db")
+	}
+	if cfg.CompactInterval == 0 {
+		// compaction is run by tests.
+		cfg.CompactInterval = seri.Duration(time.Hour)
+	}
+	bs, err := newStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bs.Close() })
+	return bs
+}
+
+// countKeys returns number of keys in each buckets.
+func countKeys(t *testing.T, bs *storage) map[string]int {
+	t.Helper()
+	counts := map[string]int{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{requestsBucket, expiresBucket, expiryBucket, createdBucket, indexBucket} {
+			n := 0
+			tx.Bucket(name).ForEach(func(_, _ []byte) error {
+				n++
+				return nil
+			})
+			counts[string(name)] = n
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+func listIDs(t *testing.T, bs *storage, opts *seri.ListOptions) string {
+	t.Helper()
+	list, err := bs.ListRequests(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(list))
+	for i, ri := range list {
+		ids[i] = ri.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestCompact(t *testing.T) {
+	bs := newTestStorage(t, &seri.Bolt{ExpireIn: seri.Duration(time.Hour)})
+	ctx := context.Background()
+	// more than a chunk of compaction.
+	items := make([]seri.BatchItem, compactChunk+1)
+	for i := range items {
+		items[i] = seri.BatchItem{ReqID: "r" + strconv.Itoa(i), Name: "ep1", Data: []byte("hello")}
+	}
+	if err := bs.StoreResponses(ctx, items); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := bs.compact(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("requests are removed before expiration: %d", n)
+	}
+	if _, err := bs.GetResponse(ctx, "r0"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = bs.compact(time.Now().Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(items) {
+		t.Errorf("unexpected number of removed requests: want=%d got=%d", len(items), n)
+	}
+	for name, n := range countKeys(t, bs) {
+		if n != 0 {
+			t.Errorf("%d keys are left in %s", n, name)
+		}
+	}
+}
+
+func TestExpiredHidden(t *testing.T) {
+	bs := newTestStorage(t, &seri.Bolt{ExpireIn: seri.Duration(time.Nanosecond)})
+	ctx := context.Background()
+	if err := bs.StoreRequest(ctx, "r1", "GET", "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	// expired, but not compacted yet.
+	if n := countKeys(t, bs)[string(requestsBucket)]; n != 1 {
+		t.Fatalf("request is not stored: %d", n)
+	}
+	if _, err := bs.GetResponse(ctx, "r1"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("expired request is got: %v", err)
+	}
+	if ids := listIDs(t, bs, &seri.ListOptions{}); ids != "" {
+		t.Errorf("expired request is listed: %s", ids)
+	}
+}
+
+func TestListRequests(t *testing.T) {
+	bs := newTestStorage(t, &seri.Bolt{})
+	ctx := context.Background()
+	for _, r := range []struct{ id, method, url string }{
+		{"r1", "GET", "/foo/1"},
+		{"r2", "POST", "/foo/2"},
+		{"r3", "GET", "/bar/3"},
+	} {
+		if err := bs.StoreRequest(ctx, r.id, r.method, r.url); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bs.StoreResponse(ctx, "r1", "a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.MarkResponse(ctx, "r3", "a", seri.MarkAborted); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := bs.ListRequests(ctx, &seri.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("unexpected number of requests: %d", len(list))
+	}
+	r3, r2, r1 := list[0], list[1], list[2]
+	if r3.ID != "r3" || r2.ID != "r2" || r1.ID != "r1" {
+		t.Fatalf("requests are not listed from newest: %s,%s,%s", r3.ID, r2.ID, r1.ID)
+	}
+	if r2.Method != "POST" || r2.URL != "/foo/2" || r2.CreatedAt.IsZero() {
+		t.Errorf("unexpected request: %+v", r2)
+	}
+	// marks are not results.
+	if strings.Join(r1.Results, ",") != "a" || len(r3.Results) != 0 {
+		t.Errorf("unexpected results: r1=%v r3=%v", r1.Results, r3.Results)
+	}
+
+	for _, tc := range []struct {
+		opts *seri.ListOptions
+		want string
+	}{
+		{&seri.ListOptions{Limit: 2}, "r3,r2"},
+		{&seri.ListOptions{Since: r2.CreatedAt}, "r3,r2"},
+		{&seri.ListOptions{Until: r2.CreatedAt}, "r1"},
+		{&seri.ListOptions{Since: r2.CreatedAt, Until: r3.CreatedAt}, "r2"},
+		{&seri.ListOptions{Method: "GET"}, "r3,r1"},
+		{&seri.ListOptions{URLPrefix: "/foo/"}, "r2,r1"},
+		{&seri.ListOptions{Missing: []string{"a"}}, "r3,r2"},
+	} {
+		if got := listIDs(t, bs, tc.opts); got != tc.want {
+			t.Errorf("unexpected list for %+v: want=%s got=%s", tc.opts, tc.want, got)
+		}
+	}
+}
+
+func TestDeleteRequest(t *testing.T) {
+	bs := newTestStorage(t, &seri.Bolt{ExpireIn: seri.Duration(time.Hour)})
+	ctx := context.Background()
+	for _, id := range []string{"r1", "r2"} {
+		if err := bs.StoreRequest(ctx, id, "GET", "/foo"); err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.StoreResponse(ctx, id, "a", []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.MarkResponse(ctx, id, "b", seri.MarkAborted); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := bs.DeleteRequest(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.GetResponse(ctx, "r1"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("deleted request is got: %v", err)
+	}
+	if ids := listIDs(t, bs, &seri.ListOptions{}); ids != "r2" {
+		t.Errorf("unexpected list: %s", ids)
+	}
+	for name, n := range countKeys(t, bs) {
+		if n != 1 {
+			t.Errorf("unexpected number of keys in %s: %d", name, n)
+		}
+	}
+	if err := bs.DeleteRequest(ctx, "r1"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error for a deleted request: %v", err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serinin.db")
+	cfg := &seri.Bolt{Path: path, ExpireIn: seri.Duration(time.Hour), Codec: "json"}
+	bs := newTestStorage(t, cfg)
+	ctx := context.Background()
+	if err := bs.StoreRequest(ctx, "r1", "GET", "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.StoreResult(ctx, "r1", "a", []byte("hello"), seri.ResultInfo{ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.MarkResponse(ctx, "r1", "b", seri.MarkAborted); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	bs = newTestStorage(t, cfg)
+	r, err := bs.GetResponse(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != "r1" || r.Method != "GET" || r.URL != "/foo" {
+		t.Errorf("unexpected request: %+v", r)
+	}
+	if a := r.Results["a"]; a == nil || string(a.Data) != "hello" || a.ContentType != "text/plain" {
+		t.Errorf("unexpected result: %+v", a)
+	}
+	if r.Marks["b"] != seri.MarkAborted {
+		t.Errorf("unexpected marks: %v", r.Marks)
+	}
+	if ids := listIDs(t, bs, &seri.ListOptions{}); ids != "r1" {
+		t.Errorf("unexpected list: %s", ids)
+	}
+	// expiration is kept too.
+	if n, err := bs.compact(time.Now().Add(2 * time.Hour)); err != nil || n != 1 {
+		t.Errorf("expired request is not compacted: n=%d err=%v", n, err)
+	}
+}
@@ -0,0 +1,78 @@
+package boltstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// compactChunk is max number of requests to be removed in a transaction, to
+// avoid blocking writes for long time.
+const compactChunk = 1000
+
+func (bs *storage) compactLoop(interval time.Duration) {
+	defer bs.wg.Done()
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-bs.done:
+			return
+		case now := <-tk.C:
+			n, err := bs.compact(now)
+			if err != nil {
+				log.Printf("[WARN] boltstore: compaction failed: %s", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("[INFO] boltstore: compaction removed %d requests", n)
+			}
+		}
+	}
+}
+
+// compact removes requests which expired until now. It returns number of
+// removed requests.
+func (bs *storage) compact(now time.Time) (int, error) {
+	limit := make([]byte, 8)
+	binary.BigEndian.PutUint64(limit, uint64(now.UnixNano()))
+	total := 0
+	for {
+		n := 0
+		err := bs.db.Update(func(tx *bolt.Tx) error {
+			rb := tx.Bucket(requestsBucket)
+			c := tx.Bucket(expiryBucket).Cursor()
+			for k, _ := c.First(); k != nil && n < compactChunk; k, _ = c.First() {
+				if len(k) < 8 || bytes.Compare(k[:8], limit) > 0 {
+					break
+				}
+				reqid := append([]byte(nil), k[8:]...)
+				if rb.Bucket(reqid) != nil {
+					if err := rb.DeleteBucket(reqid); err != nil {
+						return err
+					}
+				}
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				if err := deleteIndexes(tx, reqid); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		total += n
+		if err != nil || n < compactChunk {
+			return total, err
+		}
+		select {
+		case <-bs.done:
+			return total, nil
+		default:
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package boltstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ListRequests lists requests with the index of creation, in order from
+// newest.
+func (bs *storage) ListRequests(ctx context.Context, opts *seri.ListOptions) ([]*seri.RequestInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var since, until []byte
+	if !opts.Since.IsZero() {
+		since = make([]byte, 8)
+		binary.BigEndian.PutUint64(since, uint64(opts.Since.UnixNano()))
+	}
+	if !opts.Until.IsZero() {
+		until = make([]byte, 8)
+		binary.BigEndian.PutUint64(until, uint64(opts.Until.UnixNano()))
+	}
+	max := opts.MaxCount()
+	var list []*seri.RequestInfo
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		rb := tx.Bucket(requestsBucket)
+		c := tx.Bucket(indexBucket).Cursor()
+		now := time.Now()
+		var k []byte
+		if until != nil {
+			// move to the last key before until.
+			k, _ = c.Seek(until)
+			if k == nil {
+				k, _ = c.Last()
+			} else {
+				k, _ = c.Prev()
+			}
+		} else {
+			k, _ = c.Last()
+		}
+		for ; k != nil && len(list) < max; k, _ = c.Prev() {
+			if len(k) < 8 {
+				continue
+			}
+			if since != nil && bytes.Compare(k[:8], since) < 0 {
+				break
+			}
+			reqid := string(k[8:])
+			b := rb.Bucket(k[8:])
+			if b == nil || expired(tx, reqid, now) {
+				continue
+			}
+			ri := &seri.RequestInfo{
+				ID:        reqid,
+				Method:    string(b.Get([]byte("_method"))),
+				URL:       string(b.Get([]byte("_url"))),
+				CreatedAt: time.Unix(0, int64(binary.BigEndian.Uint64(k[:8]))),
+			}
+			b.ForEach(func(k, _ []byte) error {
+				if name := string(k); !seri.IsReservedName(name) {
+					ri.Results = append(ri.Results, name)
+				}
+				return nil
+			})
+			sort.Strings(ri.Results)
+			if opts.Match(ri) {
+				list = append(list, ri)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
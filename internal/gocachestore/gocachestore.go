@@ -1,8 +1,10 @@
 package gocachestore
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/koron/serinin/internal/seri"
@@ -11,26 +13,41 @@ import (
 
 type storage struct {
 	cache     *cache.Cache
+	keys      *seri.Keys
 	expiresIn time.Duration
 	ens       []string
 }
 
-var _ seri.Storage = (*storage)(nil)
+var (
+	_ seri.Storage      = (*storage)(nil)
+	_ seri.Marker       = (*storage)(nil)
+	_ seri.ResultStorer = (*storage)(nil)
+	_ seri.Lister       = (*storage)(nil)
+	_ seri.Deleter      = (*storage)(nil)
+)
 
 func newStore(cfg *seri.GoCache, ens []string) (*storage, error) {
 	if cfg == nil {
 		return nil, errors.New("\"cache\" configuration is not available")
 	}
+	keys, err := seri.NewKeys(cfg.KeyPrefix, cfg.KeyTemplate)
+	if err != nil {
+		return nil, err
+	}
 	c := cache.New(time.Duration(cfg.ExpireIn), 15*time.Second)
 	return &storage{
 		cache:     c,
+		keys:      keys,
 		expiresIn: time.Duration(cfg.ExpireIn),
 		ens:       ens,
 	}, nil
 }
 
-func (cs *storage) StoreRequest(reqid, method, url string) error {
-	cs.cache.SetDefault(reqid, &seri.Response{
+func (cs *storage) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cs.cache.SetDefault(cs.keys.Request(reqid), &seri.Response{
 		ID:     reqid,
 		Method: method,
 		URL:    url,
@@ -38,40 +55,135 @@ func (cs *storage) StoreRequest(reqid, method, url string) error {
 	return nil
 }
 
-func (cs *storage) StoreResponse(reqid, name string, data []byte) error {
-	cs.cache.SetDefault(reqid+"."+name, data)
+func (cs *storage) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return cs.StoreResult(ctx, reqid, name, data, seri.ResultInfo{})
+}
+
+func (cs *storage) StoreResult(ctx context.Context, reqid, name string, data []byte, info seri.ResultInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cs.cache.SetDefault(cs.keys.Result(reqid, name), &seri.Result{
+		Data:        data,
+		ContentType: info.ContentType,
+	})
 	return nil
 }
 
-func (cs *storage) GetResponse(reqid string) (*seri.Response, error) {
-	v, ok := cs.cache.Get(reqid)
+func (cs *storage) MarkResponse(ctx context.Context, reqid, name, mark string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cs.cache.SetDefault(cs.keys.Mark(reqid, name), mark)
+	return nil
+}
+
+func (cs *storage) GetResponse(ctx context.Context, reqid string) (*seri.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	v, ok := cs.cache.Get(cs.keys.Request(reqid))
 	if !ok {
-		return nil, fmt.Errorf("no requests found: %s", reqid)
+		return nil, fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
 	}
-	resp, ok := v.(*seri.Response)
+	r0, ok := v.(*seri.Response)
 	if !ok {
-		return nil, fmt.Errorf("no requests found: %s", reqid)
+		return nil, fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+	}
+	resp := &seri.Response{
+		ID:     r0.ID,
+		Method: r0.Method,
+		URL:    r0.URL,
 	}
-	resp.Results = make(map[string]string)
+	resp.Results = make(map[string]*seri.Result)
 	for _, en := range cs.ens {
-		r, ok := cs.cache.Get(reqid + "." + en)
+		if m, ok := cs.cache.Get(cs.keys.Mark(reqid, en)); ok {
+			if resp.Marks == nil {
+				resp.Marks = make(map[string]string)
+			}
+			resp.Marks[en], _ = m.(string)
+		}
+		r, ok := cs.cache.Get(cs.keys.Result(reqid, en))
 		if !ok {
 			continue
 		}
-		b, ok := r.([]byte)
+		r1, ok := r.(*seri.Result)
 		if !ok {
 			continue
 		}
-		var v string
-		if len(b) > 0 {
-			v = string(b)
+		d, err := seri.DecodeValue(r1.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode result of %s: %w", en, err)
 		}
-		resp.Results[en] = v
+		resp.Results[en] = &seri.Result{Data: d, ContentType: r1.ContentType}
 	}
 
 	return resp, nil
 }
 
+// DeleteRequest deletes a request, its results and marks from the cache.
+func (cs *storage) DeleteRequest(ctx context.Context, reqid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	keys := make([]string, 1, len(cs.ens)*2+1)
+	keys[0] = cs.keys.Request(reqid)
+	for _, en := range cs.ens {
+		keys = append(keys, cs.keys.Result(reqid, en), cs.keys.Mark(reqid, en))
+	}
+	n := 0
+	for _, k := range keys {
+		if _, ok := cs.cache.Get(k); ok {
+			n++
+		}
+		cs.cache.Delete(k)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+	}
+	return nil
+}
+
+// ListRequests lists requests in the cache. Time of a request is estimated
+// from its expiration.
+func (cs *storage) ListRequests(ctx context.Context, opts *seri.ListOptions) ([]*seri.RequestInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var list []*seri.RequestInfo
+	for _, it := range cs.cache.Items() {
+		r, ok := it.Object.(*seri.Response)
+		if !ok {
+			continue
+		}
+		ri := &seri.RequestInfo{
+			ID:     r.ID,
+			Method: r.Method,
+			URL:    r.URL,
+		}
+		if it.Expiration > 0 {
+			ri.CreatedAt = time.Unix(0, it.Expiration).Add(-cs.expiresIn)
+		}
+		for _, en := range cs.ens {
+			if _, ok := cs.cache.Get(cs.keys.Result(r.ID, en)); ok {
+				ri.Results = append(ri.Results, en)
+			}
+		}
+		sort.Strings(ri.Results)
+		if !opts.Match(ri) {
+			continue
+		}
+		list = append(list, ri)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	if max := opts.MaxCount(); len(list) > max {
+		list = list[:max]
+	}
+	return list, nil
+}
+
 func init() {
 	seri.RegisterStorage("gocache", func(cfg *seri.Config) (seri.Storage, error) {
 		return newStore(cfg.GoCache, cfg.EntryPointNames())
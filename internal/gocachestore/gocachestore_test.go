@@ -0,0 +1,99 @@
+package gocachestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	st, err := newStore(&seri.GoCache{
+		ExpireIn:    seri.Duration(time.Minute),
+		KeyPrefix:   "p:",
+		KeyTemplate: "{name}/{reqid}",
+	}, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.StoreRequest(ctx, "r1", "GET", "/x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.StoreResponse(ctx, "r1", "a", []byte("A")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MarkResponse(ctx, "r1", "b", seri.MarkAborted); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"p:r1", "p:a/r1", "p:_mark.b/r1"} {
+		if _, ok := st.cache.Get(k); !ok {
+			t.Errorf("key %q not found", k)
+		}
+	}
+	if _, ok := st.cache.Get("r1"); ok {
+		t.Error("request is stored without key_prefix")
+	}
+
+	r, err := st.GetResponse(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(r.Results["a"].Data); got != "A" {
+		t.Errorf("unexpected result: %q", got)
+	}
+	if got := r.Marks["b"]; got != seri.MarkAborted {
+		t.Errorf("unexpected mark: %q", got)
+	}
+
+	list, err := st.ListRequests(ctx, &seri.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != "r1" || len(list[0].Results) != 1 {
+		t.Errorf("unexpected list: %+v", list)
+	}
+
+	if err := st.DeleteRequest(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := st.cache.ItemCount(); n != 0 {
+		t.Errorf("%d keys are left after delete", n)
+	}
+	if err := st.DeleteRequest(ctx, "r1"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error for deleted request: %v", err)
+	}
+}
+
+func TestBinaryResult(t *testing.T) {
+	ctx := context.Background()
+	st, err := newStore(&seri.GoCache{ExpireIn: seri.Duration(time.Minute)}, []string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff, 0xfe}
+	if err := st.StoreRequest(ctx, "r1", "GET", "/image"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.StoreResult(ctx, "r1", "a", data, seri.ResultInfo{ContentType: "image/png"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := st.GetResponse(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := r.Results["a"]
+	if got == nil || !bytes.Equal(got.Data, data) || got.ContentType != "image/png" {
+		t.Errorf("unexpected result: %+v", got)
+	}
+}
+
+func TestInvalidKeyTemplate(t *testing.T) {
+	_, err := newStore(&seri.GoCache{KeyTemplate: "{reqid}"}, nil)
+	if err == nil {
+		t.Fatal("key_template without {name} should be rejected")
+	}
+}
@@ -0,0 +1,208 @@
+package memcachestore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// batcher stores values with pipelined "set" commands. For each server, it
+// writes all sets of a batch at once, then reads a reply for each, so a
+// batch is stored in a round trip per server.
+//
+// memcache.Client has no multi-set, so batcher has its own connections.
+// Servers are picked by the same server list as the client.
+type batcher struct {
+	servers *memcache.ServerList
+	timeout time.Duration
+	expiry  int32
+	maxIdle int
+
+	mu     sync.Mutex
+	idle   map[string][]net.Conn
+	closed bool
+}
+
+type setItem struct {
+	key   string
+	value []byte
+}
+
+func newBatcher(ss *memcache.ServerList, timeout time.Duration, expiry int32, maxIdle int) *batcher {
+	if maxIdle <= 0 {
+		maxIdle = memcache.DefaultMaxIdleConns
+	}
+	return &batcher{
+		servers: ss,
+		timeout: timeout,
+		expiry:  expiry,
+		maxIdle: maxIdle,
+		idle:    make(map[string][]net.Conn),
+	}
+}
+
+// set stores items. Items are grouped by servers, and stored to the servers
+// in parallel.
+func (b *batcher) set(ctx context.Context, items []setItem) error {
+	addrs := make(map[string]net.Addr)
+	groups := make(map[string][]setItem)
+	for _, it := range items {
+		if !legalKey(it.key) {
+			return fmt.Errorf("%w: %q", memcache.ErrMalformedKey, it.key)
+		}
+		addr, err := b.servers.PickServer(it.key)
+		if err != nil {
+			return err
+		}
+		s := addr.String()
+		addrs[s] = addr
+		groups[s] = append(groups[s], it)
+	}
+	errCh := make(chan error, len(groups))
+	for s, g := range groups {
+		go func(addr net.Addr, g []setItem) {
+			errCh <- b.setServer(ctx, addr, g)
+		}(addrs[s], g)
+	}
+	var errs []error
+	for range groups {
+		if err := <-errCh; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setServer stores items to a server. A connection from the pool may be
+// closed by the server already, then it retries with a new connection. Sets
+// are idempotent, so it is safe to send them again.
+func (b *batcher) setServer(ctx context.Context, addr net.Addr, items []setItem) error {
+	for {
+		conn, reused, err := b.get(addr)
+		if err != nil {
+			return fmt.Errorf("%s: %w", addr, err)
+		}
+		errs, err := b.roundTrip(ctx, conn, items)
+		if err != nil {
+			conn.Close()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if reused {
+				continue
+			}
+			return fmt.Errorf("%s: %w", addr, err)
+		}
+		b.put(addr, conn)
+		return errors.Join(errs...)
+	}
+}
+
+// roundTrip sends a batch of sets, then reads replies. It returns errors of
+// each sets in errs, and I/O or protocol errors, which break the connection,
+// in err.
+func (b *batcher) roundTrip(ctx context.Context, conn net.Conn, items []setItem) (errs []error, err error) {
+	conn.SetDeadline(time.Now().Add(b.timeout))
+	stop := context.AfterFunc(ctx, func() {
+		// interrupt blocked reads and writes.
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	var buf []byte
+	for _, it := range items {
+		buf = append(buf, "set "...)
+		buf = append(buf, it.key...)
+		buf = append(buf, " 0 "...)
+		buf = strconv.AppendInt(buf, int64(b.expiry), 10)
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, int64(len(it.value)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, it.value...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	for _, it := range items {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case bytes.Equal(line, []byte("STORED")):
+		case bytes.Equal(line, []byte("NOT_STORED")), bytes.HasPrefix(line, []byte("SERVER_ERROR ")):
+			// memcached consumes the data, so following replies are in
+			// sync.
+			errs = append(errs, fmt.Errorf("failed to set %s: %s", it.key, line))
+		default:
+			return nil, fmt.Errorf("unexpected reply: %q", line)
+		}
+	}
+	return errs, nil
+}
+
+// get returns an idle connection to the server, or a new one. reused reports
+// whether the connection is from the pool.
+func (b *batcher) get(addr net.Addr) (conn net.Conn, reused bool, err error) {
+	s := addr.String()
+	b.mu.Lock()
+	if cs := b.idle[s]; len(cs) > 0 {
+		conn = cs[len(cs)-1]
+		b.idle[s] = cs[:len(cs)-1]
+		b.mu.Unlock()
+		return conn, true, nil
+	}
+	b.mu.Unlock()
+	conn, err = net.DialTimeout(addr.Network(), s, b.timeout)
+	return conn, false, err
+}
+
+// put returns a connection to the pool, or closes it when the pool is full.
+func (b *batcher) put(addr net.Addr, conn net.Conn) {
+	s := addr.String()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || len(b.idle[s]) >= b.maxIdle {
+		conn.Close()
+		return
+	}
+	b.idle[s] = append(b.idle[s], conn)
+}
+
+func (b *batcher) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, cs := range b.idle {
+		for _, c := range cs {
+			c.Close()
+		}
+	}
+	b.idle = nil
+}
+
+// legalKey reports whether key is valid for the text protocol, as same as
+// memcache.Client checks.
+func legalKey(key string) bool {
+	if len(key) > 250 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
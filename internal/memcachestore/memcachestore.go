@@ -1,7 +1,7 @@
 package memcachestore
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -12,11 +12,21 @@ import (
 
 type store struct {
 	client    *memcache.Client
+	batch     *batcher
+	keys      *seri.Keys
 	expiresIn int32
+	codec     string
 	ens       []string
 }
 
-var _ seri.Storage = (*store)(nil)
+var (
+	_ seri.Storage      = (*store)(nil)
+	_ seri.Marker       = (*store)(nil)
+	_ seri.ResultStorer = (*store)(nil)
+	_ seri.Pinger       = (*store)(nil)
+	_ seri.Deleter      = (*store)(nil)
+	_ seri.BatchStorer  = (*store)(nil)
+)
 
 func newStore(cfg *seri.Memcache, ens []string) (*store, error) {
 	if cfg == nil {
@@ -25,81 +35,200 @@ func newStore(cfg *seri.Memcache, ens []string) (*store, error) {
 	if len(cfg.Addrs) == 0 {
 		return nil, errors.New("\"addrs\" requires one or more addresses")
 	}
+	if cfg.TLS != nil || cfg.Username != "" {
+		return nil, errors.New("\"tls\" and \"username\" are not supported by \"memcache\", use \"binmemcache\"")
+	}
 	if time.Duration(cfg.ExpireIn) < time.Second {
 		return nil, fmt.Errorf("\"expire_in\" must be larger than 1 second: %v", cfg.ExpireIn)
 	}
-	c := memcache.New(cfg.Addrs...)
+	if err := seri.CheckCodec(cfg.Codec); err != nil {
+		return nil, err
+	}
+	keys, err := seri.NewKeys(cfg.KeyPrefix, cfg.KeyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	ss := new(memcache.ServerList)
+	if err := ss.SetServers(cfg.Addrs...); err != nil {
+		return nil, err
+	}
+	c := memcache.NewFromSelector(ss)
 	if cfg.MaxIdleConns > 0 {
 		c.MaxIdleConns = cfg.MaxIdleConns
 	}
+	expiresIn := int32(time.Duration(cfg.ExpireIn) / time.Second)
 	return &store{
 		client:    c,
-		expiresIn: int32(time.Duration(cfg.ExpireIn) / time.Second),
+		batch:     newBatcher(ss, memcache.DefaultTimeout, expiresIn, cfg.MaxIdleConns),
+		keys:      keys,
+		expiresIn: expiresIn,
+		codec:     cfg.Codec,
 		ens:       ens,
 	}, nil
 }
 
-func (ms *store) StoreRequest(reqid, method, url string) error {
-	b, err := json.Marshal(&seri.Response{
-		ID:     reqid,
-		Method: method,
-		URL:    url,
+// do runs fn, but returns early when ctx is done, because memcache.Client
+// doesn't support context.
+func (ms *store) do(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ch := make(chan error, 1)
+	go func() {
+		ch <- fn()
+	}()
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (ms *store) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	b, err := seri.EncodeRequest(ms.codec, reqid, method, url)
+	if err != nil {
+		return err
+	}
+	return ms.do(ctx, func() error {
+		return ms.client.Set(&memcache.Item{
+			Key:        ms.keys.Request(reqid),
+			Value:      b,
+			Expiration: ms.expiresIn,
+		})
 	})
+}
+
+func (ms *store) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return ms.StoreResult(ctx, reqid, name, data, seri.ResultInfo{})
+}
+
+// StoreResult stores a result. Status and latency are stored only with
+// "codec".
+func (ms *store) StoreResult(ctx context.Context, reqid, name string, data []byte, info seri.ResultInfo) error {
+	v, err := seri.EncodeResult(ms.codec, data, info)
 	if err != nil {
 		return err
 	}
-	return ms.client.Set(&memcache.Item{
-		Key:        reqid,
-		Value:      b,
-		Expiration: ms.expiresIn,
+	return ms.do(ctx, func() error {
+		return ms.client.Set(&memcache.Item{
+			Key:        ms.keys.Result(reqid, name),
+			Value:      v,
+			Expiration: ms.expiresIn,
+		})
 	})
 }
 
-func (ms *store) StoreResponse(reqid, name string, data []byte) error {
-	return ms.client.Set(&memcache.Item{
-		Key:        reqid + "." + name,
-		Value:      data,
-		Expiration: ms.expiresIn,
+// StoreResponses stores results of a batch with pipelined sets, in a round
+// trip per server. It isn't transactional, so some results may be stored
+// even if it fails.
+func (ms *store) StoreResponses(ctx context.Context, items []seri.BatchItem) error {
+	sets := make([]setItem, len(items))
+	for i, it := range items {
+		v, err := seri.EncodeResult(ms.codec, it.Data, it.Info)
+		if err != nil {
+			return err
+		}
+		sets[i] = setItem{key: ms.keys.Result(it.ReqID, it.Name), value: v}
+	}
+	return ms.batch.set(ctx, sets)
+}
+
+func (ms *store) MarkResponse(ctx context.Context, reqid, name, mark string) error {
+	return ms.do(ctx, func() error {
+		return ms.client.Set(&memcache.Item{
+			Key:        ms.keys.Mark(reqid, name),
+			Value:      []byte(mark),
+			Expiration: ms.expiresIn,
+		})
 	})
 }
 
-func (ms *store) GetResponse(reqid string) (*seri.Response, error) {
-	keys := make([]string, 1, len(ms.ens))
-	keys[0] = reqid
+func (ms *store) GetResponse(ctx context.Context, reqid string) (*seri.Response, error) {
+	rkey := ms.keys.Request(reqid)
+	keys := make([]string, 1, len(ms.ens)*2+1)
+	keys[0] = rkey
 	for _, en := range ms.ens {
-		keys = append(keys, reqid+"."+en)
+		keys = append(keys, ms.keys.Result(reqid, en), ms.keys.Mark(reqid, en))
 	}
-	rs, err := ms.client.GetMulti(keys)
+	var rs map[string]*memcache.Item
+	err := ms.do(ctx, func() error {
+		var err error
+		rs, err = ms.client.GetMulti(keys)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	r0, ok := rs[reqid]
+	r0, ok := rs[rkey]
 	if !ok {
-		return nil, fmt.Errorf("no requests found: %s", reqid)
+		return nil, fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
 	}
-	resp := new(seri.Response)
-	err = json.Unmarshal(r0.Value, resp)
+	resp, err := seri.DecodeRequest(r0.Value)
 	if err != nil {
 		return nil, err
 	}
 
-	resp.Results = make(map[string]string)
+	resp.Results = make(map[string]*seri.Result)
 	for _, en := range ms.ens {
-		r, ok := rs[reqid+"."+en]
+		if m, ok := rs[ms.keys.Mark(reqid, en)]; ok {
+			if resp.Marks == nil {
+				resp.Marks = make(map[string]string)
+			}
+			resp.Marks[en] = string(m.Value)
+		}
+		r, ok := rs[ms.keys.Result(reqid, en)]
 		if !ok {
 			continue
 		}
-		var v string
-		if len(r.Value) > 0 {
-			v = string(r.Value)
+		d, info, err := seri.DecodeResult(r.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode result of %s: %w", en, err)
 		}
-		resp.Results[en] = v
+		resp.Results[en] = &seri.Result{Data: d, ContentType: info.ContentType}
 	}
 
 	return resp, nil
 }
 
+// DeleteRequest deletes keys of a request, its results and marks.
+func (ms *store) DeleteRequest(ctx context.Context, reqid string) error {
+	keys := make([]string, 1, len(ms.ens)*2+1)
+	keys[0] = ms.keys.Request(reqid)
+	for _, en := range ms.ens {
+		keys = append(keys, ms.keys.Result(reqid, en), ms.keys.Mark(reqid, en))
+	}
+	n := 0
+	for _, k := range keys {
+		err := ms.do(ctx, func() error {
+			return ms.client.Delete(k)
+		})
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+	}
+	return nil
+}
+
+// Ping checks all memcached servers are available.
+func (ms *store) Ping(ctx context.Context) error {
+	return ms.do(ctx, ms.client.Ping)
+}
+
+// Close closes idle connections of batches.
+func (ms *store) Close() error {
+	ms.batch.close()
+	return nil
+}
+
 func init() {
 	seri.RegisterStorage("memcache", func(cfg *seri.Config) (seri.Storage, error) {
 		return newStore(cfg.Memcache, cfg.EntryPointNames())
@@ -0,0 +1,48 @@
+package memcachestore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+func TestDeleteRequest(t *testing.T) {
+	s := newServer(t, 0)
+	st := newTestStore(t, s.addr())
+	ctx := context.Background()
+	if err := st.StoreRequest(ctx, "r1", "GET", "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.StoreResponse(ctx, "r1", "a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.MarkResponse(ctx, "r1", "b", seri.MarkAborted); err != nil {
+		t.Fatal(err)
+	}
+
+	// some of keys are missing.
+	if err := st.DeleteRequest(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"r1", "r1.a", "r1._mark.b"} {
+		if _, _, ok := s.get(k); ok {
+			t.Errorf("%s is not deleted", k)
+		}
+	}
+	if _, err := st.GetResponse(ctx, "r1"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error for a deleted request: %v", err)
+	}
+	// all keys are missing.
+	if err := st.DeleteRequest(ctx, "r1"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error for a deleted request: %v", err)
+	}
+
+	// other errors than misses are reported.
+	s.setBusy(true)
+	err := st.DeleteRequest(ctx, "r2")
+	if err == nil || errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
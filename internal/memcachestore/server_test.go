@@ -0,0 +1,175 @@
+package memcachestore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// server is a stand-in of memcached with text protocol, which keeps values
+// in a map.
+type server struct {
+	ln       net.Listener
+	maxValue int
+
+	mu      sync.Mutex
+	busy    bool
+	values  map[string][]byte
+	expiry  map[string]int
+	sets    int
+	flushes int
+	conns   int
+	clients []net.Conn
+}
+
+// newServer starts a server. It rejects values larger than maxValue, when
+// maxValue is positive.
+func newServer(t *testing.T, maxValue int) *server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		ln:       ln,
+		maxValue: maxValue,
+		values:   make(map[string][]byte),
+		expiry:   make(map[string]int),
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.closeConns()
+	})
+	return s
+}
+
+func (s *server) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.clients = append(s.clients, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// closeConns closes all connections from clients.
+func (s *server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		c.Close()
+	}
+	s.clients = nil
+}
+
+// handle executes commands. Replies are flushed when all received commands
+// are executed, so number of flushes is number of round trips.
+func (s *server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		if r.Buffered() == 0 && w.Buffered() > 0 {
+			s.mu.Lock()
+			s.flushes++
+			s.mu.Unlock()
+			if w.Flush() != nil {
+				return
+			}
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		if err := s.exec(r, w, args); err != nil {
+			return
+		}
+	}
+}
+
+func (s *server) exec(r *bufio.Reader, w *bufio.Writer, args []string) error {
+	switch {
+	case args[0] == "set" && len(args) == 5:
+		n, _ := strconv.Atoi(args[4])
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.sets++
+		if s.maxValue > 0 && n > s.maxValue {
+			io.WriteString(w, "SERVER_ERROR object too large for cache\r\n")
+			return nil
+		}
+		s.values[args[1]] = data[:n]
+		s.expiry[args[1]], _ = strconv.Atoi(args[3])
+		io.WriteString(w, "STORED\r\n")
+	case args[0] == "get" || args[0] == "gets":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, k := range args[1:] {
+			if v, ok := s.values[k]; ok {
+				fmt.Fprintf(w, "VALUE %s 0 %d 0\r\n%s\r\n", k, len(v), v)
+			}
+		}
+		io.WriteString(w, "END\r\n")
+	case args[0] == "delete" && len(args) == 2:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.busy {
+			io.WriteString(w, "SERVER_ERROR busy\r\n")
+			return nil
+		}
+		if _, ok := s.values[args[1]]; !ok {
+			io.WriteString(w, "NOT_FOUND\r\n")
+			return nil
+		}
+		delete(s.values, args[1])
+		io.WriteString(w, "DELETED\r\n")
+	case args[0] == "version":
+		io.WriteString(w, "VERSION 1.6.0\r\n")
+	default:
+		io.WriteString(w, "ERROR\r\n")
+	}
+	return nil
+}
+
+// setBusy makes deletes fail.
+func (s *server) setBusy(busy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy = busy
+}
+
+func (s *server) stats() (sets, flushes, conns int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sets, s.flushes, s.conns
+}
+
+func (s *server) get(key string) ([]byte, int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, s.expiry[key], ok
+}
@@ -0,0 +1,328 @@
+/*
+Package multistore provides a store which writes to several stores, and reads
+from the first one which has a request.
+*/
+package multistore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+type storage struct {
+	children []seri.Storage
+	names    []string
+
+	// async is a queue of writes to secondary stores, available when
+	// "async" is enabled.
+	async chan asyncOp
+	wg    sync.WaitGroup
+
+	// mu guards closed, to reject writes after Close, which closes async
+	// and child stores.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// errClosed is returned for writes after Close.
+var errClosed = errors.New("multi store is closed")
+
+type asyncOp struct {
+	name    string
+	timeout time.Duration
+	fn      func(context.Context) error
+}
+
+var (
+	_ seri.Storage      = (*storage)(nil)
+	_ seri.Marker       = (*storage)(nil)
+	_ seri.Completer    = (*storage)(nil)
+	_ seri.ResultStorer = (*storage)(nil)
+	_ seri.BatchStorer  = (*storage)(nil)
+	_ seri.Pinger       = (*storage)(nil)
+	_ seri.Lister       = (*storage)(nil)
+	_ seri.Deleter      = (*storage)(nil)
+)
+
+func newStorage(cfg *seri.Config) (*storage, error) {
+	mc := cfg.Multi
+	if mc == nil {
+		return nil, errors.New("\"multi\" configuration is not available")
+	}
+	if len(mc.StoreTypes) == 0 {
+		return nil, errors.New("\"store_types\" of \"multi\" is required")
+	}
+	ms := &storage{}
+	for _, t := range mc.StoreTypes {
+		if t == "multi" {
+			return nil, errors.New("\"multi\" can't be a child of \"multi\"")
+		}
+		c := cfg.Clone()
+		c.StoreType = t
+		st, err := seri.NewStorage(&c)
+		if err != nil {
+			ms.Close()
+			return nil, fmt.Errorf("failed to create %q store: %w", t, err)
+		}
+		ms.children = append(ms.children, st)
+		ms.names = append(ms.names, t)
+	}
+	if mc.Async && len(ms.children) > 1 {
+		qsize := mc.AsyncQueueSize
+		if qsize <= 0 {
+			qsize = 1000
+		}
+		workers := mc.AsyncWorkers
+		if workers <= 0 {
+			workers = 4
+		}
+		ms.async = make(chan asyncOp, qsize)
+		for i := 0; i < workers; i++ {
+			ms.wg.Add(1)
+			go ms.asyncWorker()
+		}
+	}
+	return ms, nil
+}
+
+// write applies fn to all children. Writes to secondary stores are queued
+// when "async" is enabled, otherwise all writes are done in parallel and
+// errors of them are joined. It fails with errClosed after Close.
+func (ms *storage) write(ctx context.Context, fn func(context.Context, seri.Storage) error) error {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if ms.closed {
+		return errClosed
+	}
+	if ms.async != nil {
+		err := fn(ctx, ms.children[0])
+		var timeout time.Duration
+		if d, ok := ctx.Deadline(); ok {
+			timeout = time.Until(d)
+		}
+		for i, st := range ms.children[1:] {
+			st := st
+			op := asyncOp{
+				name:    ms.names[i+1],
+				timeout: timeout,
+				fn:      func(ctx context.Context) error { return fn(ctx, st) },
+			}
+			select {
+			case ms.async <- op:
+			default:
+				log.Printf("[WARN] multistore: queue is full, dropped a write to %q store", op.name)
+			}
+		}
+		return err
+	}
+
+	errs := make([]error, len(ms.children))
+	var wg sync.WaitGroup
+	for i, st := range ms.children {
+		wg.Add(1)
+		go func(i int, st seri.Storage) {
+			defer wg.Done()
+			if err := fn(ctx, st); err != nil {
+				errs[i] = fmt.Errorf("%q store: %w", ms.names[i], err)
+			}
+		}(i, st)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (ms *storage) asyncWorker() {
+	defer ms.wg.Done()
+	for op := range ms.async {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if op.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, op.timeout)
+		}
+		err := op.fn(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("[WARN] multistore: failed to write to %q store: %s", op.name, err)
+		}
+	}
+}
+
+func (ms *storage) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	return ms.write(ctx, func(ctx context.Context, st seri.Storage) error {
+		return st.StoreRequest(ctx, reqid, method, url)
+	})
+}
+
+func (ms *storage) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return ms.write(ctx, func(ctx context.Context, st seri.Storage) error {
+		return st.StoreResponse(ctx, reqid, name, data)
+	})
+}
+
+func (ms *storage) StoreResult(ctx context.Context, reqid, name string, data []byte, info seri.ResultInfo) error {
+	return ms.write(ctx, func(ctx context.Context, st seri.Storage) error {
+		return storeResult(ctx, st, seri.BatchItem{ReqID: reqid, Name: name, Data: data, Info: info})
+	})
+}
+
+func (ms *storage) StoreResponses(ctx context.Context, items []seri.BatchItem) error {
+	return ms.write(ctx, func(ctx context.Context, st seri.Storage) error {
+		if bs, ok := st.(seri.BatchStorer); ok {
+			return bs.StoreResponses(ctx, items)
+		}
+		for _, it := range items {
+			if err := storeResult(ctx, st, it); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func storeResult(ctx context.Context, st seri.Storage, it seri.BatchItem) error {
+	if rs, ok := st.(seri.ResultStorer); ok {
+		return rs.StoreResult(ctx, it.ReqID, it.Name, it.Data, it.Info)
+	}
+	return st.StoreResponse(ctx, it.ReqID, it.Name, it.Data)
+}
+
+func (ms *storage) MarkResponse(ctx context.Context, reqid, name, mark string) error {
+	return ms.write(ctx, func(ctx context.Context, st seri.Storage) error {
+		if m, ok := st.(seri.Marker); ok {
+			return m.MarkResponse(ctx, reqid, name, mark)
+		}
+		return nil
+	})
+}
+
+func (ms *storage) CompleteRequest(ctx context.Context, reqid string) error {
+	return ms.write(ctx, func(ctx context.Context, st seri.Storage) error {
+		if c, ok := st.(seri.Completer); ok {
+			return c.CompleteRequest(ctx, reqid)
+		}
+		return nil
+	})
+}
+
+// DeleteRequest deletes a request from all child stores. It returns
+// seri.ErrNotFound when no stores have the request. When "async" is enabled,
+// it is decided by the primary store.
+func (ms *storage) DeleteRequest(ctx context.Context, reqid string) error {
+	var found int32
+	err := ms.write(ctx, func(ctx context.Context, st seri.Storage) error {
+		d, ok := st.(seri.Deleter)
+		if !ok {
+			return nil
+		}
+		err := d.DeleteRequest(ctx, reqid)
+		if errors.Is(err, seri.ErrNotFound) {
+			return nil
+		}
+		if err == nil {
+			atomic.AddInt32(&found, 1)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&found) == 0 {
+		return fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+	}
+	return nil
+}
+
+// GetResponse gets a response from the first child store which has the
+// request.
+func (ms *storage) GetResponse(ctx context.Context, reqid string) (*seri.Response, error) {
+	var errs []error
+	for i, st := range ms.children {
+		r, err := st.GetResponse(ctx, reqid)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q store: %w", ms.names[i], err))
+			continue
+		}
+		// some stores return an empty response for unknown requests.
+		if r == nil || r.ID == "" {
+			continue
+		}
+		return r, nil
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nil, fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+}
+
+// Ping checks all child stores.
+func (ms *storage) Ping(ctx context.Context) error {
+	var errs []error
+	for i, st := range ms.children {
+		p, ok := st.(seri.Pinger)
+		if !ok {
+			continue
+		}
+		if err := p.Ping(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%q store: %w", ms.names[i], err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ListRequests lists requests with the first child store which supports
+// listing.
+func (ms *storage) ListRequests(ctx context.Context, opts *seri.ListOptions) ([]*seri.RequestInfo, error) {
+	for i, st := range ms.children {
+		l, ok := st.(seri.Lister)
+		if !ok {
+			continue
+		}
+		list, err := l.ListRequests(ctx, opts)
+		if errors.Is(err, seri.ErrNotSupported) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%q store: %w", ms.names[i], err)
+		}
+		return list, nil
+	}
+	return nil, seri.ErrNotSupported
+}
+
+// Close waits writes in progress and queued writes to secondary stores,
+// then closes child stores.
+func (ms *storage) Close() error {
+	ms.mu.Lock()
+	if ms.closed {
+		ms.mu.Unlock()
+		return nil
+	}
+	ms.closed = true
+	ms.mu.Unlock()
+	if ms.async != nil {
+		close(ms.async)
+		ms.wg.Wait()
+	}
+	var errs []error
+	for _, st := range ms.children {
+		if c, ok := st.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func init() {
+	seri.RegisterStorage("multi", func(cfg *seri.Config) (seri.Storage, error) {
+		return newStorage(cfg)
+	})
+}
@@ -0,0 +1,220 @@
+package multistore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/koron/serinin/internal/gocachestore"
+	"github.com/koron/serinin/internal/seri"
+)
+
+func newTestStorage(t *testing.T, async bool) *storage {
+	t.Helper()
+	ms, err := newStorage(&seri.Config{
+		GoCache: &seri.GoCache{},
+		Multi: &seri.Multi{
+			StoreTypes: []string{"gocache", "discard"},
+			Async:      async,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ms.Close() })
+	return ms
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		ms := newTestStorage(t, async)
+		ctx := context.Background()
+		if err := ms.StoreRequest(ctx, "r1", "GET", "/foo"); err != nil {
+			t.Fatalf("async=%t: %s", async, err)
+		}
+		if err := ms.Close(); err != nil {
+			t.Fatalf("async=%t: %s", async, err)
+		}
+		if err := ms.StoreResponse(ctx, "r1", "ep1", []byte("hello")); !errors.Is(err, errClosed) {
+			t.Errorf("async=%t: unexpected error after close: %v", async, err)
+		}
+		// Close is idempotent.
+		if err := ms.Close(); err != nil {
+			t.Errorf("async=%t: second close failed: %s", async, err)
+		}
+	}
+}
+
+// TestCloseWhileWriting checks writes racing with Close, which closed the
+// queue of async writes under them and made them panic.
+func TestCloseWhileWriting(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		ms := newTestStorage(t, true)
+		ctx := context.Background()
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 100; k++ {
+					err := ms.StoreResponse(ctx, "r1", "ep1", []byte("hello"))
+					if errors.Is(err, errClosed) {
+						return
+					}
+					if err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		ms.Close()
+		wg.Wait()
+	}
+}
+
+// newGoCaches creates a multi store with two gocache stores, for endpoints
+// "a", "b" and "c".
+func newGoCaches(t *testing.T, async bool) *storage {
+	t.Helper()
+	ms, err := newStorage(&seri.Config{
+		Endpoints: map[string]seri.Endpoint{"a": {}, "b": {}, "c": {}},
+		GoCache:   &seri.GoCache{},
+		Multi: &seri.Multi{
+			StoreTypes: []string{"gocache", "gocache"},
+			Async:      async,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ms.Close() })
+	return ms
+}
+
+// writeAll writes a request, results and a mark with st.
+func writeAll(t *testing.T, st seri.Storage, reqid string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := st.StoreRequest(ctx, reqid, "GET", "/"+reqid); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.(seri.ResultStorer).StoreResult(ctx, reqid, "a", []byte("hello a"), seri.ResultInfo{ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if bs, ok := st.(seri.BatchStorer); ok {
+		err = bs.StoreResponses(ctx, []seri.BatchItem{{ReqID: reqid, Name: "b", Data: []byte("hello b")}})
+	} else {
+		err = st.StoreResponse(ctx, reqid, "b", []byte("hello b"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.(seri.Marker).MarkResponse(ctx, reqid, "c", seri.MarkAborted); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hasAll checks a response which is written by writeAll.
+func hasAll(st seri.Storage, reqid string) error {
+	r, err := st.GetResponse(context.Background(), reqid)
+	if err != nil {
+		return err
+	}
+	if r.URL != "/"+reqid {
+		return fmt.Errorf("unexpected URL: %s", r.URL)
+	}
+	if a := r.Results["a"]; a == nil || string(a.Data) != "hello a" || a.ContentType != "text/plain" {
+		return fmt.Errorf("unexpected result of a: %+v", a)
+	}
+	if b := r.Results["b"]; b == nil || string(b.Data) != "hello b" {
+		return fmt.Errorf("unexpected result of b: %+v", b)
+	}
+	if r.Marks["c"] != seri.MarkAborted {
+		return fmt.Errorf("unexpected marks: %v", r.Marks)
+	}
+	return nil
+}
+
+func TestWriteAll(t *testing.T) {
+	ms := newGoCaches(t, false)
+	writeAll(t, ms, "r1")
+	for i, st := range ms.children {
+		if err := hasAll(st, "r1"); err != nil {
+			t.Errorf("child #%d: %s", i, err)
+		}
+	}
+}
+
+func TestWriteAsync(t *testing.T) {
+	ms := newGoCaches(t, true)
+	writeAll(t, ms, "r1")
+	// the primary store is written synchronously.
+	if err := hasAll(ms.children[0], "r1"); err != nil {
+		t.Fatalf("primary: %s", err)
+	}
+	var err error
+	for i := 0; i < 100; i++ {
+		if err = hasAll(ms.children[1], "r1"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Errorf("secondary: %s", err)
+	}
+}
+
+func TestReadFallback(t *testing.T) {
+	ms := newGoCaches(t, false)
+	ctx := context.Background()
+	writeAll(t, ms.children[1], "r1")
+	if err := hasAll(ms, "r1"); err != nil {
+		t.Errorf("failed to read from the second store: %s", err)
+	}
+
+	// the first store which has a request is read.
+	if err := ms.children[0].StoreRequest(ctx, "r2", "GET", "/first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.children[1].StoreRequest(ctx, "r2", "GET", "/second"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := ms.GetResponse(ctx, "r2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.URL != "/first" {
+		t.Errorf("unexpected URL: %s", r.URL)
+	}
+
+	if _, err := ms.GetResponse(ctx, "r3"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error for an unknown request: %v", err)
+	}
+}
+
+func TestDeleteRequest(t *testing.T) {
+	ms := newGoCaches(t, false)
+	ctx := context.Background()
+	writeAll(t, ms, "r1")
+	writeAll(t, ms.children[1], "r2")
+
+	for _, id := range []string{"r1", "r2"} {
+		if err := ms.DeleteRequest(ctx, id); err != nil {
+			t.Errorf("failed to delete %s: %s", id, err)
+		}
+		for i, st := range ms.children {
+			if _, err := st.GetResponse(ctx, id); !errors.Is(err, seri.ErrNotFound) {
+				t.Errorf("%s is not deleted from child #%d: %v", id, i, err)
+			}
+		}
+	}
+	// no stores have the request.
+	if err := ms.DeleteRequest(ctx, "r1"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error for a deleted request: %v", err)
+	}
+}
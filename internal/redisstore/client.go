@@ -0,0 +1,82 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+
+	redis "github.com/go-redis/redis/v7"
+	"github.com/koron/serinin/internal/seri"
+)
+
+// newClient creates a client for single node, sentinel or cluster, depending
+// on the configuration.
+func newClient(cfg *seri.Redis) (redis.UniversalClient, bool, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:    []string{cfg.Addr},
+		DB:       cfg.DBNum,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		PoolSize: cfg.PoolSize,
+	}
+	if cfg.TLS != nil {
+		tc, err := cfg.TLS.Config()
+		if err != nil {
+			return nil, false, err
+		}
+		opts.TLSConfig = tc
+	}
+	switch {
+	case cfg.MasterName != "":
+		if len(cfg.SentinelAddrs) == 0 {
+			return nil, false, errors.New("\"sentinel_addrs\" requires one or more addresses")
+		}
+		opts.Addrs = cfg.SentinelAddrs
+		opts.MasterName = cfg.MasterName
+		fo := opts.Failover()
+		fo.SentinelPassword = cfg.SentinelPassword
+		return redis.NewFailoverClient(fo), false, nil
+	case len(cfg.ClusterAddrs) > 0:
+		opts.Addrs = cfg.ClusterAddrs
+		return redis.NewClusterClient(opts.Cluster()), true, nil
+	default:
+		if cfg.Addr == "" {
+			return nil, false, errors.New("\"addr\" is required")
+		}
+		return redis.NewClient(opts.Simple()), false, nil
+	}
+}
+
+// withContext returns a client which is bound with the context.
+func (rs *storage) withContext(ctx context.Context) redis.Cmdable {
+	switch c := rs.client.(type) {
+	case *redis.Client:
+		return c.WithContext(ctx)
+	case *redis.ClusterClient:
+		return c.WithContext(ctx)
+	default:
+		return c
+	}
+}
+
+// pipeline returns a pipeline to update keys of a request atomically. It is a
+// transaction, except for cluster which may not have the keys on one node.
+func (rs *storage) pipeline(ctx context.Context) redis.Pipeliner {
+	if rs.cluster {
+		return rs.withContext(ctx).Pipeline()
+	}
+	return rs.withContext(ctx).TxPipeline()
+}
+
+// tag returns the request ID as a hash tag for cluster, to store all keys for
+// a request in one slot. For others, it returns the request ID as is.
+func (rs *storage) tag(reqid string) string {
+	if rs.cluster {
+		return "{" + reqid + "}"
+	}
+	return reqid
+}
+
+// key returns a key for the request, with "key_prefix".
+func (rs *storage) key(reqid string) string {
+	return rs.prefix + rs.tag(reqid)
+}
@@ -0,0 +1,90 @@
+package redisstore
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/koron/serinin/internal/seri"
+	"github.com/koron/serinin/internal/tlstest"
+)
+
+func TestClientTLS(t *testing.T) {
+	certs := tlstest.New(t)
+	m, err := miniredis.RunTLS(certs.ServerConfig(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	c, _, err := newClient(&seri.Redis{
+		Addr: m.Addr(),
+		TLS: &seri.TLS{
+			CAFile:     certs.CAFile,
+			CertFile:   certs.ClientCertFile,
+			KeyFile:    certs.ClientKeyFile,
+			ServerName: tlstest.ServerName,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Ping().Err(); err != nil {
+		t.Fatalf("ping failed: %s", err)
+	}
+}
+
+func TestClientTLSWithoutClientCert(t *testing.T) {
+	certs := tlstest.New(t)
+	m, err := miniredis.RunTLS(certs.ServerConfig(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	c, _, err := newClient(&seri.Redis{
+		Addr: m.Addr(),
+		TLS: &seri.TLS{
+			CAFile:     certs.CAFile,
+			ServerName: tlstest.ServerName,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Ping().Err(); err == nil {
+		t.Fatal("ping should fail without client certificate")
+	}
+}
+
+func TestClientACL(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.RequireUserAuth("serinin", "secret")
+
+	c, _, err := newClient(&seri.Redis{
+		Addr:     m.Addr(),
+		Username: "serinin",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Ping().Err(); err != nil {
+		t.Fatalf("ping failed: %s", err)
+	}
+
+	c2, _, err := newClient(&seri.Redis{
+		Addr:     m.Addr(),
+		Username: "serinin",
+		Password: "wrong",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	if err := c2.Ping().Err(); err == nil {
+		t.Fatal("ping should fail with wrong password")
+	}
+}
@@ -0,0 +1,110 @@
+package redisstore
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	redis "github.com/go-redis/redis/v7"
+	"github.com/koron/serinin/internal/seri"
+)
+
+// listPageSize is number of requests to be read from the index at once.
+const listPageSize = 100
+
+// indexKey returns key of the sorted set, which indexes requests by time.
+func (rs *storage) indexKey() string {
+	return rs.prefix + "_index"
+}
+
+// queueIndex queues commands to add a request to the index, and to remove
+// expired requests from the index. The index itself expires when no requests
+// are added for "expire_in".
+func (rs *storage) queueIndex(p redis.Pipeliner, reqid string, now time.Time) {
+	key := rs.indexKey()
+	p.ZAdd(key, &redis.Z{Score: float64(msec(now)), Member: reqid})
+	if rs.expiresIn > 0 {
+		min := msec(now.Add(-time.Duration(rs.expiresIn)))
+		p.ZRemRangeByScore(key, "-inf", "("+strconv.FormatInt(min, 10))
+		p.Expire(key, time.Duration(rs.expiresIn))
+	}
+}
+
+func msec(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// ListRequests lists requests with the index, which is available when
+// "index" is enabled.
+func (rs *storage) ListRequests(ctx context.Context, opts *seri.ListOptions) ([]*seri.RequestInfo, error) {
+	if !rs.index {
+		return nil, seri.ErrNotSupported
+	}
+	zr := &redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: listPageSize}
+	if !opts.Since.IsZero() {
+		zr.Min = strconv.FormatInt(msec(opts.Since), 10)
+	}
+	if !opts.Until.IsZero() {
+		zr.Max = "(" + strconv.FormatInt(msec(opts.Until), 10)
+	}
+	c := rs.withContext(ctx)
+	max := opts.MaxCount()
+	var list []*seri.RequestInfo
+	for {
+		zs, err := c.ZRevRangeByScoreWithScores(rs.indexKey(), zr).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(zs) == 0 {
+			return list, nil
+		}
+		zr.Offset += int64(len(zs))
+
+		p := c.Pipeline()
+		keys := make([]*redis.StringSliceCmd, len(zs))
+		infos := make([]*redis.SliceCmd, len(zs))
+		for i, z := range zs {
+			key := rs.key(z.Member.(string))
+			keys[i] = p.HKeys(key)
+			infos[i] = p.HMGet(key, "_method", "_url")
+		}
+		// errors are checked for each commands.
+		p.Exec()
+		for i, z := range zs {
+			fields, err := keys[i].Result()
+			if err != nil && err != redis.Nil {
+				return nil, err
+			}
+			if len(fields) == 0 {
+				// expired request.
+				continue
+			}
+			vals, err := infos[i].Result()
+			if err != nil && err != redis.Nil {
+				return nil, err
+			}
+			ri := &seri.RequestInfo{
+				ID:        z.Member.(string),
+				CreatedAt: time.Unix(0, int64(z.Score)*int64(time.Millisecond)),
+			}
+			if len(vals) == 2 {
+				ri.Method, _ = vals[0].(string)
+				ri.URL, _ = vals[1].(string)
+			}
+			for _, k := range fields {
+				if !seri.IsReservedName(k) {
+					ri.Results = append(ri.Results, k)
+				}
+			}
+			sort.Strings(ri.Results)
+			if !opts.Match(ri) {
+				continue
+			}
+			list = append(list, ri)
+			if len(list) >= max {
+				return list, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package redisstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	redis "github.com/go-redis/redis/v7"
+	"github.com/koron/serinin/internal/seri"
+)
+
+// Events of notifications.
+const (
+	eventResult   = "result"
+	eventMark     = "mark"
+	eventComplete = "complete"
+)
+
+// reqidPlaceholder is replaced with request ID in name of channels.
+const reqidPlaceholder = "{reqid}"
+
+// notifier notifies events of requests, with PUBLISH or XADD.
+type notifier struct {
+	mode      string
+	channel   string
+	maxLen    int64
+	expiresIn time.Duration
+}
+
+type message struct {
+	RequestID string `json:"request_id"`
+	Event     string `json:"event"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	Mark      string `json:"mark,omitempty"`
+}
+
+func newNotifier(cfg *seri.RedisNotify, expiresIn seri.Duration) (*notifier, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	switch cfg.Mode {
+	case "publish", "stream":
+	default:
+		return nil, fmt.Errorf("unsupported \"notify.mode\": %q", cfg.Mode)
+	}
+	ch := cfg.Channel
+	if ch == "" {
+		ch = "serinin"
+	}
+	return &notifier{
+		mode:      cfg.Mode,
+		channel:   ch,
+		maxLen:    cfg.MaxLen,
+		expiresIn: time.Duration(expiresIn),
+	}, nil
+}
+
+// notify queues a notification to the pipeline. tag is the request ID or its
+// hash tag, which replaces "{reqid}" in name of the channel.
+func (n *notifier) notify(p redis.Pipeliner, tag string, m *message) {
+	ch := strings.ReplaceAll(n.channel, reqidPlaceholder, tag)
+	switch n.mode {
+	case "publish":
+		b, _ := json.Marshal(m)
+		p.Publish(ch, b)
+	case "stream":
+		v := map[string]interface{}{
+			"request_id": m.RequestID,
+			"event":      m.Event,
+		}
+		if m.Endpoint != "" {
+			v["endpoint"] = m.Endpoint
+		}
+		if m.Mark != "" {
+			v["mark"] = m.Mark
+		}
+		p.XAdd(&redis.XAddArgs{
+			Stream:       ch,
+			MaxLenApprox: n.maxLen,
+			Values:       v,
+		})
+		// streams for each requests expire with the request. A shared
+		// stream expires when no events are added for the duration. TTL is
+		// refreshed always, because the stream is created by XADD.
+		if n.expiresIn > 0 {
+			p.Expire(ch, n.expiresIn)
+		}
+	}
+}
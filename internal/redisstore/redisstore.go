@@ -1,7 +1,9 @@
 package redisstore
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
@@ -10,70 +12,218 @@ import (
 )
 
 type storage struct {
-	client    *redis.Client
+	client    redis.UniversalClient
+	cluster   bool
+	prefix    string
 	expiresIn seri.Duration
+	refresh   bool
+	index     bool
+	codec     string
+	notifier  *notifier
 }
 
-var _ seri.Storage = (*storage)(nil)
+var (
+	_ seri.Storage      = (*storage)(nil)
+	_ seri.Marker       = (*storage)(nil)
+	_ seri.Completer    = (*storage)(nil)
+	_ seri.BatchStorer  = (*storage)(nil)
+	_ seri.ResultStorer = (*storage)(nil)
+	_ seri.Pinger       = (*storage)(nil)
+	_ seri.Lister       = (*storage)(nil)
+	_ seri.Deleter      = (*storage)(nil)
+)
 
 func newStorage(cfg *seri.Redis) (*storage, error) {
 	if cfg == nil {
 		return nil, errors.New("\"redis\" configuration is not available")
 	}
+	if err := seri.CheckCodec(cfg.Codec); err != nil {
+		return nil, err
+	}
+	n, err := newNotifier(cfg.Notify, cfg.ExpireIn)
+	if err != nil {
+		return nil, err
+	}
+	c, cluster, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
 	return &storage{
-		client: redis.NewClient(&redis.Options{
-			Addr:     cfg.Addr,
-			Password: cfg.Password,
-			DB:       cfg.DBNum,
-			PoolSize: cfg.PoolSize,
-		}),
+		client:    c,
+		cluster:   cluster,
+		prefix:    cfg.KeyPrefix,
 		expiresIn: cfg.ExpireIn,
+		refresh:   cfg.ExpireRefresh,
+		index:     cfg.Index,
+		codec:     cfg.Codec,
+		notifier:  n,
 	}, nil
 }
 
-func (rs *storage) StoreRequest(reqid, method, url string) error {
-	p := rs.client.TxPipeline()
-	p.HMSet(reqid, map[string]interface{}{
+func (rs *storage) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	key := rs.key(reqid)
+	p := rs.pipeline(ctx)
+	p.HMSet(key, map[string]interface{}{
 		"_id":     reqid,
 		"_method": method,
 		"_url":    url,
 	}).Result()
 	if rs.expiresIn > 0 {
-		p.Expire(reqid, time.Duration(rs.expiresIn)).Result()
+		p.Expire(key, time.Duration(rs.expiresIn)).Result()
+	}
+	if rs.index {
+		rs.queueIndex(p, reqid, time.Now())
 	}
 	_, err := p.Exec()
 	return err
 }
 
-func (rs *storage) StoreResponse(reqid, name string, data []byte) error {
-	_, err := rs.client.HSet(reqid, name, data).Result()
+// hsetScript sets a field of a hash, and ensures the hash has TTL. TTL is
+// set when the hash has no TTL, or ARGV[4] is "1" (refresh).
+var hsetScript = redis.NewScript(`
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+if ARGV[4] == '1' or redis.call('PTTL', KEYS[1]) < 0 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+func (rs *storage) hsetArgs(field string, value interface{}) []interface{} {
+	refresh := "0"
+	if rs.refresh {
+		refresh = "1"
+	}
+	ms := time.Duration(rs.expiresIn).Milliseconds()
+	return []interface{}{field, value, ms, refresh}
+}
+
+// hset sets a field of the hash for a request, with keeping TTL of the hash.
+// A notification is sent with it, when notifier is available.
+func (rs *storage) hset(ctx context.Context, reqid, field string, value interface{}, m *message) error {
+	if rs.notifier == nil {
+		c := rs.withContext(ctx)
+		key := rs.key(reqid)
+		if rs.expiresIn <= 0 {
+			return c.HSet(key, field, value).Err()
+		}
+		return hsetScript.Run(c, []string{key}, rs.hsetArgs(field, value)...).Err()
+	}
+	p := rs.pipeline(ctx)
+	rs.queueHset(p, reqid, field, value, m)
+	_, err := p.Exec()
+	return err
+}
+
+// queueHset queues commands of hset to the pipeline.
+func (rs *storage) queueHset(p redis.Pipeliner, reqid, field string, value interface{}, m *message) {
+	key := rs.key(reqid)
+	if rs.expiresIn <= 0 {
+		p.HSet(key, field, value)
+	} else {
+		hsetScript.Eval(p, []string{key}, rs.hsetArgs(field, value)...)
+	}
+	if rs.notifier != nil {
+		rs.notifier.notify(p, rs.tag(reqid), m)
+	}
+}
+
+// StoreResponses stores results of a batch with a pipeline. It isn't
+// transactional, so some results may be stored even if it fails.
+func (rs *storage) StoreResponses(ctx context.Context, items []seri.BatchItem) error {
+	p := rs.withContext(ctx).Pipeline()
+	for _, it := range items {
+		v, err := seri.EncodeResult(rs.codec, it.Data, it.Info)
+		if err != nil {
+			return err
+		}
+		rs.queueHset(p, it.ReqID, it.Name, v, &message{RequestID: it.ReqID, Event: eventResult, Endpoint: it.Name})
+	}
+	_, err := p.Exec()
+	return err
+}
+
+func (rs *storage) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return rs.StoreResult(ctx, reqid, name, data, seri.ResultInfo{})
+}
+
+// StoreResult stores a result. Status and latency are stored only with
+// "codec".
+func (rs *storage) StoreResult(ctx context.Context, reqid, name string, data []byte, info seri.ResultInfo) error {
+	v, err := seri.EncodeResult(rs.codec, data, info)
 	if err != nil {
 		return err
 	}
-	return nil
+	return rs.hset(ctx, reqid, name, v, &message{RequestID: reqid, Event: eventResult, Endpoint: name})
+}
+
+func (rs *storage) MarkResponse(ctx context.Context, reqid, name, mark string) error {
+	return rs.hset(ctx, reqid, seri.MarkPrefix+name, mark, &message{RequestID: reqid, Event: eventMark, Endpoint: name, Mark: mark})
+}
+
+func (rs *storage) CompleteRequest(ctx context.Context, reqid string) error {
+	if rs.notifier == nil {
+		return nil
+	}
+	p := rs.withContext(ctx).Pipeline()
+	rs.notifier.notify(p, rs.tag(reqid), &message{RequestID: reqid, Event: eventComplete})
+	_, err := p.Exec()
+	return err
 }
 
-func (rs *storage) GetResponse(reqid string) (*seri.Response, error) {
-	m, err := rs.client.HGetAll(reqid).Result()
+func (rs *storage) GetResponse(ctx context.Context, reqid string) (*seri.Response, error) {
+	m, err := rs.withContext(ctx).HGetAll(rs.key(reqid)).Result()
 	if err != nil {
 		return nil, err
 	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+	}
 	r := &seri.Response{
 		ID:      m["_id"],
 		Method:  m["_method"],
 		URL:     m["_url"],
-		Results: make(map[string]string),
+		Results: make(map[string]*seri.Result),
 	}
 	for k, v := range m {
-		if strings.HasPrefix(k, "_") {
-			// FIXME: use dictionary to reserved keywords.
+		if strings.HasPrefix(k, seri.MarkPrefix) {
+			if r.Marks == nil {
+				r.Marks = make(map[string]string)
+			}
+			r.Marks[k[len(seri.MarkPrefix):]] = v
+			continue
+		}
+		if seri.IsReservedName(k) {
 			continue
 		}
-		r.Results[k] = v
+		d, info, err := seri.DecodeResult([]byte(v))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode result of %s: %w", k, err)
+		}
+		r.Results[k] = &seri.Result{Data: d, ContentType: info.ContentType}
 	}
 	return r, nil
 }
 
+// DeleteRequest deletes the hash of a request, and removes it from the index.
+func (rs *storage) DeleteRequest(ctx context.Context, reqid string) error {
+	p := rs.pipeline(ctx)
+	del := p.Del(rs.key(reqid))
+	if rs.index {
+		p.ZRem(rs.indexKey(), reqid)
+	}
+	if _, err := p.Exec(); err != nil {
+		return err
+	}
+	if del.Val() == 0 {
+		return fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+	}
+	return nil
+}
+
+func (rs *storage) Ping(ctx context.Context) error {
+	return rs.withContext(ctx).Ping().Err()
+}
+
 func init() {
 	seri.RegisterStorage("redis", func(cfg *seri.Config) (seri.Storage, error) {
 		return newStorage(cfg.Redis)
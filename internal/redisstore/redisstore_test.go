@@ -0,0 +1,193 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/koron/serinin/internal/seri"
+)
+
+const testExpireIn = time.Minute
+
+func newTestStorage(t *testing.T, cfg *seri.Redis) (*storage, *miniredis.Miniredis) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	cfg.Addr = m.Addr()
+	if cfg.ExpireIn == 0 {
+		cfg.ExpireIn = seri.Duration(testExpireIn)
+	}
+	rs, err := newStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rs.client.Close() })
+	return rs, m
+}
+
+// checkTTL checks all keys have TTL.
+func checkTTL(t *testing.T, m *miniredis.Miniredis) {
+	t.Helper()
+	keys := m.Keys()
+	if len(keys) == 0 {
+		t.Fatal("no keys")
+	}
+	for _, k := range keys {
+		if ttl := m.TTL(k); ttl <= 0 {
+			t.Errorf("key %q has no TTL", k)
+		}
+	}
+}
+
+// storeAll stores a request with all kinds of writes.
+func storeAll(t *testing.T, rs *storage, reqid string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := rs.StoreRequest(ctx, reqid, "GET", "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.StoreResponse(ctx, reqid, "ep1", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.StoreResult(ctx, reqid, "ep2", []byte("world"), seri.ResultInfo{Status: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.MarkResponse(ctx, reqid, "ep3", seri.MarkAborted); err != nil {
+		t.Fatal(err)
+	}
+	err := rs.StoreResponses(ctx, []seri.BatchItem{{ReqID: reqid, Name: "ep4", Data: []byte("batch")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.CompleteRequest(ctx, reqid); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var ttlCases = []struct {
+	name string
+	cfg  func() *seri.Redis
+}{
+	{"plain", func() *seri.Redis { return &seri.Redis{} }},
+	{"index", func() *seri.Redis { return &seri.Redis{Index: true} }},
+	{"stream", func() *seri.Redis {
+		return &seri.Redis{Notify: &seri.RedisNotify{Mode: "stream"}}
+	}},
+	{"stream per request", func() *seri.Redis {
+		return &seri.Redis{Notify: &seri.RedisNotify{Mode: "stream", Channel: "serinin:{reqid}"}}
+	}},
+	{"publish", func() *seri.Redis {
+		return &seri.Redis{Notify: &seri.RedisNotify{Mode: "publish"}}
+	}},
+	{"all", func() *seri.Redis {
+		return &seri.Redis{
+			KeyPrefix: "p:",
+			Index:     true,
+			Codec:     "msgpack",
+			Notify:    &seri.RedisNotify{Mode: "stream", Channel: "serinin:{reqid}"},
+		}
+	}},
+}
+
+func TestTTL(t *testing.T) {
+	for _, tc := range ttlCases {
+		for _, refresh := range []bool{false, true} {
+			cfg := tc.cfg()
+			cfg.ExpireRefresh = refresh
+			name := tc.name
+			if refresh {
+				name += " with expire_refresh"
+			}
+			t.Run(name, func(t *testing.T) {
+				rs, m := newTestStorage(t, cfg)
+				storeAll(t, rs, "r1")
+				checkTTL(t, m)
+			})
+		}
+	}
+}
+
+func TestTTLAfterExpired(t *testing.T) {
+	for _, tc := range ttlCases {
+		for _, refresh := range []bool{false, true} {
+			cfg := tc.cfg()
+			cfg.ExpireRefresh = refresh
+			name := tc.name
+			if refresh {
+				name += " with expire_refresh"
+			}
+			t.Run(name, func(t *testing.T) {
+				rs, m := newTestStorage(t, cfg)
+				ctx := context.Background()
+				if err := rs.StoreRequest(ctx, "r1", "GET", "/foo"); err != nil {
+					t.Fatal(err)
+				}
+				m.FastForward(testExpireIn + time.Second)
+				if n := len(m.Keys()); n != 0 {
+					t.Fatalf("%d keys are left after expiration", n)
+				}
+				// late results create the hash again, it must have TTL.
+				if err := rs.StoreResponse(ctx, "r1", "ep1", []byte("late")); err != nil {
+					t.Fatal(err)
+				}
+				if err := rs.MarkResponse(ctx, "r1", "ep2", seri.MarkTruncated); err != nil {
+					t.Fatal(err)
+				}
+				if err := rs.CompleteRequest(ctx, "r1"); err != nil {
+					t.Fatal(err)
+				}
+				checkTTL(t, m)
+			})
+		}
+	}
+}
+
+func TestExpireRefresh(t *testing.T) {
+	for _, refresh := range []bool{false, true} {
+		rs, m := newTestStorage(t, &seri.Redis{ExpireRefresh: refresh})
+		ctx := context.Background()
+		if err := rs.StoreRequest(ctx, "r1", "GET", "/foo"); err != nil {
+			t.Fatal(err)
+		}
+		m.FastForward(testExpireIn / 2)
+		if err := rs.StoreResponse(ctx, "r1", "ep1", []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		want := testExpireIn / 2
+		if refresh {
+			want = testExpireIn
+		}
+		if got := m.TTL("r1"); got != want {
+			t.Errorf("unexpected TTL with expire_refresh=%t: want=%s got=%s", refresh, want, got)
+		}
+	}
+}
+
+func TestStoreAndGet(t *testing.T) {
+	rs, _ := newTestStorage(t, &seri.Redis{Index: true})
+	storeAll(t, rs, "r1")
+	ctx := context.Background()
+	r, err := rs.GetResponse(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != "r1" || r.Method != "GET" || r.URL != "/foo" {
+		t.Errorf("unexpected request: %+v", r)
+	}
+	for name, want := range map[string]string{"ep1": "hello", "ep2": "world", "ep4": "batch"} {
+		if got := r.Results[name]; got == nil || string(got.Data) != want {
+			t.Errorf("unexpected result of %s: %+v", name, got)
+		}
+	}
+	if r.Marks["ep3"] != seri.MarkAborted {
+		t.Errorf("unexpected marks: %+v", r.Marks)
+	}
+
+	if err := rs.DeleteRequest(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.GetResponse(ctx, "r1"); err == nil {
+		t.Error("deleted request is found")
+	}
+}
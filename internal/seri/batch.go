@@ -0,0 +1,258 @@
+package seri
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// BatchItem is a result of an endpoint to be stored in a batch.
+type BatchItem struct {
+	ReqID string
+	Name  string
+	Data  []byte
+	Info  ResultInfo
+}
+
+// BatchStorer is an optional capability of Storage, to store multiple
+// results at once (ex. pipeline).
+type BatchStorer interface {
+	StoreResponses(ctx context.Context, items []BatchItem) error
+}
+
+// batchStorage coalesces StoreResponse calls into batches, and stores them
+// with BatchStorer of underlying Storage. StoreResponse waits for its batch
+// to be stored, so errors are reported to each callers.
+type batchStorage struct {
+	Storage
+
+	size   int
+	window time.Duration
+	ch     chan *batchItem
+
+	mu      sync.RWMutex
+	closed  bool
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+type batchItem struct {
+	ctx   context.Context
+	item  BatchItem
+	errCh chan error
+}
+
+var (
+	_ Storage      = (*batchStorage)(nil)
+	_ Marker       = (*batchStorage)(nil)
+	_ Completer    = (*batchStorage)(nil)
+	_ ResultStorer = (*batchStorage)(nil)
+	_ Pinger       = (*batchStorage)(nil)
+	_ Lister       = (*batchStorage)(nil)
+	_ Deleter      = (*batchStorage)(nil)
+)
+
+func newBatchStorage(st Storage, cf *Batch) *batchStorage {
+	size := cf.Size
+	if size <= 0 {
+		size = 100
+	}
+	window := time.Duration(cf.Window)
+	if window <= 0 {
+		window = time.Millisecond
+	}
+	qsize := cf.QueueSize
+	if qsize <= 0 {
+		qsize = size * 10
+	}
+	bs := &batchStorage{
+		Storage: st,
+		size:    size,
+		window:  window,
+		ch:      make(chan *batchItem, qsize),
+		closing: make(chan struct{}),
+	}
+	bs.wg.Add(1)
+	go bs.run()
+	return bs
+}
+
+func (bs *batchStorage) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return bs.StoreResult(ctx, reqid, name, data, ResultInfo{})
+}
+
+// StoreResult queues a result to be stored in a batch. It blocks when the
+// queue is full (backpressure), until ctx is done.
+func (bs *batchStorage) StoreResult(ctx context.Context, reqid, name string, data []byte, info ResultInfo) error {
+	it := &batchItem{
+		ctx:   ctx,
+		item:  BatchItem{ReqID: reqid, Name: name, Data: data, Info: info},
+		errCh: make(chan error, 1),
+	}
+	bs.mu.RLock()
+	if bs.closed {
+		bs.mu.RUnlock()
+		return storeResult(ctx, bs.Storage, reqid, name, data, info)
+	}
+	select {
+	case bs.ch <- it:
+		bs.mu.RUnlock()
+	case <-ctx.Done():
+		bs.mu.RUnlock()
+		return ctx.Err()
+	}
+	select {
+	case err := <-it.errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (bs *batchStorage) MarkResponse(ctx context.Context, reqid, name, mark string) error {
+	if m, ok := bs.Storage.(Marker); ok {
+		return m.MarkResponse(ctx, reqid, name, mark)
+	}
+	return nil
+}
+
+func (bs *batchStorage) CompleteRequest(ctx context.Context, reqid string) error {
+	if c, ok := bs.Storage.(Completer); ok {
+		return c.CompleteRequest(ctx, reqid)
+	}
+	return nil
+}
+
+func (bs *batchStorage) Ping(ctx context.Context) error {
+	if p, ok := bs.Storage.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// DeleteRequest deletes a request from the underlying Storage. Results of
+// the request which are queued may be written after it.
+func (bs *batchStorage) DeleteRequest(ctx context.Context, reqid string) error {
+	if d, ok := bs.Storage.(Deleter); ok {
+		return d.DeleteRequest(ctx, reqid)
+	}
+	return ErrNotSupported
+}
+
+func (bs *batchStorage) ListRequests(ctx context.Context, opts *ListOptions) ([]*RequestInfo, error) {
+	if l, ok := bs.Storage.(Lister); ok {
+		return l.ListRequests(ctx, opts)
+	}
+	return nil, ErrNotSupported
+}
+
+// Close flushes all queued results, and stops batching. Then it closes the
+// underlying Storage if it is io.Closer.
+func (bs *batchStorage) Close() error {
+	bs.mu.Lock()
+	if bs.closed {
+		bs.mu.Unlock()
+		return nil
+	}
+	bs.closed = true
+	bs.mu.Unlock()
+	close(bs.closing)
+	bs.wg.Wait()
+	if c, ok := bs.Storage.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (bs *batchStorage) run() {
+	defer bs.wg.Done()
+	timer := time.NewTimer(bs.window)
+	timer.Stop()
+	var items []*batchItem
+	flush := func() {
+		if len(items) == 0 {
+			return
+		}
+		bs.wg.Add(1)
+		go bs.flush(items)
+		items = nil
+	}
+	for {
+		select {
+		case it := <-bs.ch:
+			if len(items) == 0 {
+				timer.Reset(bs.window)
+			}
+			items = append(items, it)
+			if len(items) >= bs.size {
+				timer.Stop()
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		case <-bs.closing:
+			timer.Stop()
+			for {
+				select {
+				case it := <-bs.ch:
+					items = append(items, it)
+					continue
+				default:
+				}
+				break
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// flush stores a batch, and reports the result to callers.
+func (bs *batchStorage) flush(items []*batchItem) {
+	defer bs.wg.Done()
+	bst, ok := bs.Storage.(BatchStorer)
+	if !ok {
+		// store each results in parallel, when the storage doesn't support
+		// batch.
+		for _, it := range items {
+			bs.wg.Add(1)
+			go func(it *batchItem) {
+				defer bs.wg.Done()
+				it.errCh <- storeResult(it.ctx, bs.Storage, it.item.ReqID, it.item.Name, it.item.Data, it.item.Info)
+			}(it)
+		}
+		return
+	}
+
+	// the batch is given up when the earliest deadline of items is exceeded.
+	var (
+		deadline time.Time
+		batch    = make([]BatchItem, 0, len(items))
+		targets  = make([]*batchItem, 0, len(items))
+	)
+	for _, it := range items {
+		if err := it.ctx.Err(); err != nil {
+			it.errCh <- err
+			continue
+		}
+		if d, ok := it.ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+			deadline = d
+		}
+		batch = append(batch, it.item)
+		targets = append(targets, it)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		x, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		ctx = x
+	}
+	err := bst.StoreResponses(ctx, batch)
+	for _, it := range targets {
+		it.errCh <- err
+	}
+}
@@ -0,0 +1,127 @@
+package seri
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// latencyStore is a fake Storage which takes latency for each writes, like a
+// round trip to a remote store with a limited number of connections.
+type latencyStore struct {
+	latency time.Duration
+	conns   chan struct{}
+	writes  int64
+}
+
+func newLatencyStore(latency time.Duration, conns int) *latencyStore {
+	return &latencyStore{latency: latency, conns: make(chan struct{}, conns)}
+}
+
+func (ls *latencyStore) wait(ctx context.Context) error {
+	select {
+	case ls.conns <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-ls.conns }()
+	atomic.AddInt64(&ls.writes, 1)
+	select {
+	case <-time.After(ls.latency):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (ls *latencyStore) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	return ls.wait(ctx)
+}
+
+func (ls *latencyStore) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return ls.wait(ctx)
+}
+
+func (ls *latencyStore) GetResponse(ctx context.Context, reqid string) (*Response, error) {
+	return nil, ErrNotFound
+}
+
+// latencyBatchStore is latencyStore which stores a batch in a round trip.
+type latencyBatchStore struct {
+	latencyStore
+}
+
+func (ls *latencyBatchStore) StoreResponses(ctx context.Context, items []BatchItem) error {
+	return ls.wait(ctx)
+}
+
+const (
+	benchLatency = 100 * time.Microsecond
+	benchConns   = 4
+)
+
+func benchStore(b *testing.B, st Storage) {
+	data := []byte("hello")
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		ctx := context.Background()
+		for pb.Next() {
+			if err := st.StoreResponse(ctx, "r1", "ep1", data); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+}
+
+func reportWrites(b *testing.B, ls *latencyStore) {
+	b.ReportMetric(float64(atomic.LoadInt64(&ls.writes))/float64(b.N), "writes/op")
+}
+
+func BenchmarkDirect(b *testing.B) {
+	ls := newLatencyStore(benchLatency, benchConns)
+	benchStore(b, ls)
+	reportWrites(b, ls)
+}
+
+func BenchmarkBatch(b *testing.B) {
+	ls := &latencyBatchStore{*newLatencyStore(benchLatency, benchConns)}
+	bs := newBatchStorage(ls, &Batch{})
+	benchStore(b, bs)
+	bs.Close()
+	reportWrites(b, &ls.latencyStore)
+}
+
+// BenchmarkBatchFallback measures batching against a store without
+// BatchStorer, which writes each results of a batch in parallel.
+func BenchmarkBatchFallback(b *testing.B) {
+	ls := newLatencyStore(benchLatency, benchConns)
+	bs := newBatchStorage(ls, &Batch{})
+	benchStore(b, bs)
+	bs.Close()
+	reportWrites(b, ls)
+}
+
+func TestBatchStoresInBatches(t *testing.T) {
+	ls := &latencyBatchStore{*newLatencyStore(time.Millisecond, 1)}
+	bs := newBatchStorage(ls, &Batch{Size: 10, Window: Duration(10 * time.Millisecond)})
+	defer bs.Close()
+	ctx := context.Background()
+	errCh := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			errCh <- bs.StoreResponse(ctx, "r1", "ep1", nil)
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt64(&ls.writes); n != 1 {
+		t.Errorf("10 results should be stored in a batch: writes=%d", n)
+	}
+}
@@ -0,0 +1,250 @@
+package seri_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/koron/serinin/internal/gocachestore"
+	"github.com/koron/serinin/internal/seri"
+)
+
+// newTestBroker creates a broker with a gocache store, and serves it with
+// an httptest server.
+func newTestBroker(t *testing.T, cf *seri.Config) (*seri.Broker, seri.Storage, *httptest.Server) {
+	t.Helper()
+	if cf.StoreType == "" {
+		cf.StoreType = "gocache"
+	}
+	if cf.GoCache == nil {
+		cf.GoCache = &seri.GoCache{ExpireIn: seri.Duration(time.Minute)}
+	}
+	st, err := seri.NewStorage(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := seri.NewBroker(cf, seri.WithStorage(st))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	ts := httptest.NewServer(b.Handler())
+	t.Cleanup(ts.Close)
+	return b, st, ts
+}
+
+// blockingEndpoint returns an endpoint which doesn't respond until the
+// request is canceled.
+func blockingEndpoint(t *testing.T) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the context isn't canceled by closing the connection, until the
+		// body is read.
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	// the server can't be closed while requests are blocked.
+	t.Cleanup(func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	})
+	return ts.URL
+}
+
+// textEndpoint returns an endpoint which responds text after delay.
+func textEndpoint(t *testing.T, text string, delay time.Duration) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		io.WriteString(w, text)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// send sends a request to a broker and returns its status code and ID of
+// the request.
+func send(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var v struct {
+		RequestID string `json:"request_id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&v)
+	return resp.StatusCode, v.RequestID
+}
+
+// waitResponse polls the store until fn accepts the response.
+func waitResponse(t *testing.T, st seri.Storage, id string, fn func(*seri.Response) bool) *seri.Response {
+	t.Helper()
+	for i := 0; i < 300; i++ {
+		r, err := st.GetResponse(context.Background(), id)
+		if err == nil && r != nil && fn(r) {
+			return r
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("response of %s is not stored", id)
+	return nil
+}
+
+func hasResults(n int) func(*seri.Response) bool {
+	return func(r *seri.Response) bool { return len(r.Results) >= n }
+}
+
+func TestShutdownDrains(t *testing.T) {
+	b, st, ts := newTestBroker(t, &seri.Config{
+		Endpoints: map[string]seri.Endpoint{
+			"fast": {URL: textEndpoint(t, "fast", 0)},
+			"slow": {URL: textEndpoint(t, "slow", 200*time.Millisecond)},
+		},
+	})
+	code, id := send(t, "GET", ts.URL, "")
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", code)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown failed: %s", err)
+	}
+	r, err := st.GetResponse(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Results) != 2 || len(r.Marks) != 0 {
+		t.Errorf("accepted inquiries are not finished: results=%d marks=%v", len(r.Results), r.Marks)
+	}
+}
+
+func TestShutdownAborts(t *testing.T) {
+	b, st, ts := newTestBroker(t, &seri.Config{
+		Endpoints: map[string]seri.Endpoint{
+			"fast":  {URL: textEndpoint(t, "fast", 0)},
+			"stuck": {URL: blockingEndpoint(t)},
+		},
+	})
+	code, id := send(t, "GET", ts.URL, "")
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", code)
+	}
+	waitResponse(t, st, id, hasResults(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- b.Shutdown(ctx) }()
+
+	// new requests are rejected while closing.
+	for i := 0; ; i++ {
+		code, _ := send(t, "GET", ts.URL, "")
+		if code == http.StatusServiceUnavailable {
+			break
+		}
+		if i >= 100 {
+			t.Fatalf("request is not rejected while closing: %d", code)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error of shutdown: %v", err)
+	}
+	r, err := st.GetResponse(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Results["fast"] == nil {
+		t.Error("finished result is lost")
+	}
+	if got := r.Marks["stuck"]; got != seri.MarkAborted {
+		t.Errorf("unfinished inquiry is not marked as aborted: %q", got)
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// TestServeShutdownTimeout checks that shutdown of the HTTP server and
+// draining of inquiries end within "shutdown_timeout" in total.
+func TestServeShutdownTimeout(t *testing.T) {
+	const timeout = 500 * time.Millisecond
+	addr := freeAddr(t)
+	cf := &seri.Config{
+		Addr:            addr,
+		ShutdownTimeout: seri.Duration(timeout),
+		Endpoints:       map[string]seri.Endpoint{"stuck": {URL: blockingEndpoint(t)}},
+		StoreType:       "gocache",
+		GoCache:         &seri.GoCache{},
+	}
+	b, err := seri.NewBroker(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		b.Serve(ctx)
+		close(done)
+	}()
+
+	// an accepted inquiry, which is never finished.
+	url := fmt.Sprintf("http://%s/", addr)
+	for i := 0; ; i++ {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if i >= 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// a request which is never finished, blocks shutdown of the HTTP server.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: %s\r\nContent-Length: 100\r\n\r\npartial", addr)
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve doesn't return")
+	}
+	if d := time.Since(start); d > timeout+timeout/2 {
+		t.Errorf("shutdown took %s, longer than shutdown_timeout %s", d, timeout)
+	}
+}
@@ -0,0 +1,379 @@
+package seri
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tinylib/msgp/msgp"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// recordMagic is a marker at head of records in stores. A record is formed
+// as: recordMagic + version + ":" + codec + ":" + encoded record.
+var recordMagic = []byte("\x00serirec:")
+
+// recordVersion is current version of the envelope of records.
+const recordVersion = 1
+
+// Record is a request or a result, which is stored as a value by stores.
+type Record struct {
+	// ID, Method and URL are for a request.
+	ID     string
+	Method string
+	URL    string
+
+	// Data, Status, Latency and ContentType are for a result. Data may be
+	// encoded by EncodeValue.
+	Data        []byte
+	Status      int
+	Latency     time.Duration
+	ContentType string
+}
+
+// Codec encodes and decodes records.
+type Codec interface {
+	Marshal(r *Record) ([]byte, error)
+	Unmarshal(b []byte, r *Record) error
+}
+
+var codecs = map[string]Codec{
+	"json":     jsonCodec{},
+	"msgpack":  msgpackCodec{},
+	"protobuf": protobufCodec{},
+}
+
+// CheckCodec checks a codec is available. Empty name is available, which
+// means layouts of older versions: JSON for requests and raw bytes for
+// results.
+func CheckCodec(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := codecs[name]; !ok {
+		return fmt.Errorf("unsupported codec: %q", name)
+	}
+	return nil
+}
+
+// EncodeRecord encodes a record with a codec in the envelope.
+func EncodeRecord(codec string, r *Record) ([]byte, error) {
+	c, ok := codecs[codec]
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec: %q", codec)
+	}
+	data, err := c.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, len(recordMagic)+len(codec)+3+len(data))
+	b = append(b, recordMagic...)
+	b = strconv.AppendInt(b, recordVersion, 10)
+	b = append(b, ':')
+	b = append(b, codec...)
+	b = append(b, ':')
+	return append(b, data...), nil
+}
+
+// DecodeRecord decodes a record in the envelope. It returns false when b
+// isn't in the envelope.
+func DecodeRecord(b []byte) (*Record, bool, error) {
+	if !bytes.HasPrefix(b, recordMagic) {
+		return nil, false, nil
+	}
+	rest := b[len(recordMagic):]
+	n := bytes.IndexByte(rest, ':')
+	if n < 0 {
+		return nil, true, errors.New("broken record: no version")
+	}
+	if v, err := strconv.Atoi(string(rest[:n])); err != nil || v > recordVersion {
+		return nil, true, fmt.Errorf("unsupported version of record: %q", rest[:n])
+	}
+	rest = rest[n+1:]
+	n = bytes.IndexByte(rest, ':')
+	if n < 0 {
+		return nil, true, errors.New("broken record: no codec")
+	}
+	c, ok := codecs[string(rest[:n])]
+	if !ok {
+		return nil, true, fmt.Errorf("unsupported codec of record: %q", rest[:n])
+	}
+	r := &Record{}
+	if err := c.Unmarshal(rest[n+1:], r); err != nil {
+		return nil, true, err
+	}
+	return r, true, nil
+}
+
+// EncodeRequest encodes a request with a codec. Empty codec encodes it as
+// JSON of Response, which is the layout of older versions.
+func EncodeRequest(codec, reqid, method, url string) ([]byte, error) {
+	if codec == "" {
+		return json.Marshal(&Response{ID: reqid, Method: method, URL: url})
+	}
+	return EncodeRecord(codec, &Record{ID: reqid, Method: method, URL: url})
+}
+
+// DecodeRequest decodes a request which was encoded by EncodeRequest, with
+// any codecs.
+func DecodeRequest(b []byte) (*Response, error) {
+	r, ok, err := DecodeRecord(b)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		resp := &Response{}
+		if err := json.Unmarshal(b, resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+	return &Response{ID: r.ID, Method: r.Method, URL: r.URL}, nil
+}
+
+// EncodeResult encodes a result with a codec. Empty codec returns data as
+// is, which is the layout of older versions. But data which starts with
+// recordMagic is encoded with "json" codec, otherwise DecodeResult takes it
+// as a record.
+func EncodeResult(codec string, data []byte, info ResultInfo) ([]byte, error) {
+	if codec == "" {
+		if !bytes.HasPrefix(data, recordMagic) {
+			return data, nil
+		}
+		codec = "json"
+	}
+	return EncodeRecord(codec, &Record{
+		Data:        data,
+		Status:      info.Status,
+		Latency:     info.Latency,
+		ContentType: info.ContentType,
+	})
+}
+
+// DecodeResult decodes a result which was encoded by EncodeResult, with any
+// codecs. Data is decoded by DecodeValue too.
+func DecodeResult(b []byte) ([]byte, ResultInfo, error) {
+	r, ok, err := DecodeRecord(b)
+	if err != nil {
+		return nil, ResultInfo{}, err
+	}
+	if !ok {
+		d, err := DecodeValue(b)
+		return d, ResultInfo{}, err
+	}
+	d, err := DecodeValue(r.Data)
+	return d, ResultInfo{Status: r.Status, Latency: r.Latency, ContentType: r.ContentType}, err
+}
+
+// jsonCodec encodes records as JSON objects.
+type jsonCodec struct{}
+
+type jsonRecord struct {
+	ID      string `json:"id,omitempty"`
+	Method  string `json:"method,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Data    []byte `json:"data,omitempty"`
+	Status  int    `json:"status,omitempty"`
+	Latency int64  `json:"latency,omitempty"`
+
+	ContentType string `json:"content_type,omitempty"`
+}
+
+func (jsonCodec) Marshal(r *Record) ([]byte, error) {
+	return json.Marshal(&jsonRecord{
+		ID:      r.ID,
+		Method:  r.Method,
+		URL:     r.URL,
+		Data:    r.Data,
+		Status:  r.Status,
+		Latency: int64(r.Latency),
+
+		ContentType: r.ContentType,
+	})
+}
+
+func (jsonCodec) Unmarshal(b []byte, r *Record) error {
+	var jr jsonRecord
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return err
+	}
+	*r = Record{
+		ID:      jr.ID,
+		Method:  jr.Method,
+		URL:     jr.URL,
+		Data:    jr.Data,
+		Status:  jr.Status,
+		Latency: time.Duration(jr.Latency),
+
+		ContentType: jr.ContentType,
+	}
+	return nil
+}
+
+// msgpackCodec encodes records as MessagePack maps, which have same keys as
+// jsonCodec. Unknown keys are skipped.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(r *Record) ([]byte, error) {
+	var n uint32
+	for _, ok := range []bool{r.ID != "", r.Method != "", r.URL != "", r.Data != nil, r.Status != 0, r.Latency != 0, r.ContentType != ""} {
+		if ok {
+			n++
+		}
+	}
+	b := msgp.AppendMapHeader(nil, n)
+	if r.ID != "" {
+		b = msgp.AppendString(msgp.AppendString(b, "id"), r.ID)
+	}
+	if r.Method != "" {
+		b = msgp.AppendString(msgp.AppendString(b, "method"), r.Method)
+	}
+	if r.URL != "" {
+		b = msgp.AppendString(msgp.AppendString(b, "url"), r.URL)
+	}
+	if r.Data != nil {
+		b = msgp.AppendBytes(msgp.AppendString(b, "data"), r.Data)
+	}
+	if r.Status != 0 {
+		b = msgp.AppendInt(msgp.AppendString(b, "status"), r.Status)
+	}
+	if r.Latency != 0 {
+		b = msgp.AppendInt64(msgp.AppendString(b, "latency"), int64(r.Latency))
+	}
+	if r.ContentType != "" {
+		b = msgp.AppendString(msgp.AppendString(b, "content_type"), r.ContentType)
+	}
+	return b, nil
+}
+
+func (msgpackCodec) Unmarshal(b []byte, r *Record) error {
+	n, b, err := msgp.ReadMapHeaderBytes(b)
+	if err != nil {
+		return err
+	}
+	*r = Record{}
+	for i := uint32(0); i < n; i++ {
+		var key string
+		key, b, err = msgp.ReadStringBytes(b)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "id":
+			r.ID, b, err = msgp.ReadStringBytes(b)
+		case "method":
+			r.Method, b, err = msgp.ReadStringBytes(b)
+		case "url":
+			r.URL, b, err = msgp.ReadStringBytes(b)
+		case "data":
+			r.Data, b, err = msgp.ReadBytesBytes(b, nil)
+		case "status":
+			r.Status, b, err = msgp.ReadIntBytes(b)
+		case "latency":
+			var d int64
+			d, b, err = msgp.ReadInt64Bytes(b)
+			r.Latency = time.Duration(d)
+		case "content_type":
+			r.ContentType, b, err = msgp.ReadStringBytes(b)
+		default:
+			b, err = msgp.Skip(b)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// protobufCodec encodes records as protocol buffers messages, which are
+// compatible with this definition:
+//
+//	message Record {
+//	  string id = 1;
+//	  string method = 2;
+//	  string url = 3;
+//	  bytes data = 4;
+//	  int32 status = 5;
+//	  int64 latency = 6; // nanoseconds
+//	  string content_type = 7;
+//	}
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(r *Record) ([]byte, error) {
+	var b []byte
+	for i, s := range []string{r.ID, r.Method, r.URL} {
+		if s != "" {
+			b = protowire.AppendTag(b, protowire.Number(i+1), protowire.BytesType)
+			b = protowire.AppendString(b, s)
+		}
+	}
+	if r.Data != nil {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, r.Data)
+	}
+	if r.Status != 0 {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int32(r.Status)))
+	}
+	if r.Latency != 0 {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(r.Latency))
+	}
+	if r.ContentType != "" {
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendString(b, r.ContentType)
+	}
+	return b, nil
+}
+
+func (protobufCodec) Unmarshal(b []byte, r *Record) error {
+	*r = Record{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case (num >= 1 && num <= 4 || num == 7) && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 1:
+				r.ID = string(v)
+			case 2:
+				r.Method = string(v)
+			case 3:
+				r.URL = string(v)
+			case 4:
+				r.Data = append([]byte{}, v...)
+			case 7:
+				r.ContentType = string(v)
+			}
+		case (num == 5 || num == 6) && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			if num == 5 {
+				r.Status = int(int32(v))
+			} else {
+				r.Latency = time.Duration(int64(v))
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package seri
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestResultCodecs(t *testing.T) {
+	info := ResultInfo{Status: 200, Latency: time.Second, ContentType: "text/plain"}
+	for _, codec := range []string{"json", "msgpack", "protobuf"} {
+		v, err := EncodeResult(codec, []byte("hello"), info)
+		if err != nil {
+			t.Fatalf("%s: %s", codec, err)
+		}
+		d, got, err := DecodeResult(v)
+		if err != nil {
+			t.Fatalf("%s: %s", codec, err)
+		}
+		if string(d) != "hello" || got != info {
+			t.Errorf("%s: unexpected result: %q %+v", codec, d, got)
+		}
+	}
+}
+
+func TestResultWithoutCodec(t *testing.T) {
+	for _, body := range [][]byte{
+		[]byte("hello"),
+		// raw bodies which look like a record.
+		[]byte("\x00serirec:1:json:{}"),
+		[]byte("\x00serirec:broken"),
+	} {
+		v, err := EncodeResult("", body, ResultInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, _, err := DecodeResult(v)
+		if err != nil {
+			t.Fatalf("%q: %s", body, err)
+		}
+		if !bytes.Equal(d, body) {
+			t.Errorf("body is changed: want=%q got=%q", body, d)
+		}
+	}
+	// others are stored as is, the layout of older versions.
+	if v, _ := EncodeResult("", []byte("hello"), ResultInfo{}); string(v) != "hello" {
+		t.Errorf("unexpected value: %q", v)
+	}
+}
+
+func TestRequestCodecs(t *testing.T) {
+	for _, codec := range []string{"", "json", "msgpack", "protobuf"} {
+		v, err := EncodeRequest(codec, "r1", "GET", "/foo")
+		if err != nil {
+			t.Fatalf("%q: %s", codec, err)
+		}
+		r, err := DecodeRequest(v)
+		if err != nil {
+			t.Fatalf("%q: %s", codec, err)
+		}
+		if r.ID != "r1" || r.Method != "GET" || r.URL != "/foo" {
+			t.Errorf("%q: unexpected request: %+v", codec, r)
+		}
+	}
+}
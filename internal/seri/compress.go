@@ -0,0 +1,149 @@
+package seri
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// valueMagic is a marker at head of encoded values in stores. An encoded
+// value is formed as: valueMagic + encoding + ":" + encoded data.
+var valueMagic = []byte("\x00seri:")
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// EncodeValue encodes a value with a marker of the encoding, to be decoded
+// by DecodeValue.
+func EncodeValue(encoding string, data []byte) []byte {
+	b := make([]byte, 0, len(valueMagic)+len(encoding)+1+len(data))
+	b = append(b, valueMagic...)
+	b = append(b, encoding...)
+	b = append(b, ':')
+	return append(b, data...)
+}
+
+// DecodeValue decodes a value which was encoded by EncodeValue. Values
+// without the marker are returned as is.
+func DecodeValue(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, valueMagic) {
+		return b, nil
+	}
+	rest := b[len(valueMagic):]
+	n := bytes.IndexByte(rest, ':')
+	if n < 0 {
+		return nil, errors.New("broken encoded value: no encoding")
+	}
+	return decompress(string(rest[:n]), rest[n+1:], 0)
+}
+
+// compress compresses data with an algorithm: "zstd" or "gzip".
+func compress(algorithm string, data []byte) ([]byte, error) {
+	switch algorithm {
+	case "zstd":
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case "gzip":
+		bb := &bytes.Buffer{}
+		zw := gzip.NewWriter(bb)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return bb.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %q", algorithm)
+	}
+}
+
+// decompress decompresses data which compressed by an algorithm: "zstd",
+// "gzip" or "br". It returns decompressed data as far as possible with an
+// error, when data is broken. When max is larger than zero, it stops after
+// max+1 bytes, so callers can detect that the data exceeds max.
+func decompress(algorithm string, data []byte, max int64) ([]byte, error) {
+	var r io.Reader
+	switch algorithm {
+	case "identity":
+		return data, nil
+	case "zstd":
+		if max <= 0 {
+			return zstdDecoder.DecodeAll(data, nil)
+		}
+		zr, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported compression: %q", algorithm)
+	}
+	if max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	return ioutil.ReadAll(r)
+}
+
+// contentEncoding normalizes value of "Content-Encoding" header.
+func contentEncoding(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return "identity"
+	}
+	return s
+}
+
+// encodeResult converts a response body from an endpoint to a value to be
+// stored, according to "compression" configuration. Decoded body is limited
+// by "max_response_size" too, and it returns true as truncated when the body
+// is truncated.
+func (b *Broker) encodeResult(ce string, data []byte, truncated bool) ([]byte, bool, error) {
+	cc := b.cf.Compression
+	ce = contentEncoding(ce)
+	if ce != "identity" {
+		if cc != nil && cc.StoreEncoded && !truncated {
+			return EncodeValue(ce, data), false, nil
+		}
+		max := b.cf.MaxResponseSize
+		d, err := decompress(ce, data, max)
+		if err != nil && !(truncated && len(d) > 0) {
+			return nil, truncated, fmt.Errorf("failed to decode %q: %w", ce, err)
+		}
+		if max > 0 && int64(len(d)) > max {
+			d = d[:max]
+			truncated = true
+		}
+		data = d
+	}
+	if cc == nil || cc.Algorithm == "" || len(data) < cc.Threshold {
+		// data which looks like an encoded value is marked as "identity",
+		// not to be decoded by DecodeValue.
+		if bytes.HasPrefix(data, valueMagic) {
+			return EncodeValue("identity", data), truncated, nil
+		}
+		return data, truncated, nil
+	}
+	d, err := compress(cc.Algorithm, data)
+	if err != nil {
+		return nil, truncated, err
+	}
+	return EncodeValue(cc.Algorithm, d), truncated, nil
+}
@@ -0,0 +1,88 @@
+package seri
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	bb := &bytes.Buffer{}
+	zw := gzip.NewWriter(bb)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func brotliBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	bb := &bytes.Buffer{}
+	zw := brotli.NewWriter(bb)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func TestEncodeResultLimitsDecompression(t *testing.T) {
+	bomb := make([]byte, 16<<20)
+	for _, tc := range []struct {
+		ce   string
+		data []byte
+	}{
+		{"gzip", gzipBytes(t, bomb)},
+		{"br", brotliBytes(t, bomb)},
+		{"zstd", zstdEncoder.EncodeAll(bomb, nil)},
+	} {
+		t.Run(tc.ce, func(t *testing.T) {
+			b := &Broker{cf: Config{MaxResponseSize: 1024}}
+			d, truncated, err := b.encodeResult(tc.ce, tc.data, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !truncated {
+				t.Error("should be truncated")
+			}
+			if len(d) != 1024 {
+				t.Errorf("unexpected length: want=1024 got=%d", len(d))
+			}
+		})
+	}
+}
+
+func TestEncodeResultWithinLimit(t *testing.T) {
+	body := []byte("hello compressed world")
+	b := &Broker{cf: Config{MaxResponseSize: int64(len(body))}}
+	d, truncated, err := b.encodeResult("gzip", gzipBytes(t, body), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated {
+		t.Error("should not be truncated")
+	}
+	if !bytes.Equal(d, body) {
+		t.Errorf("unexpected body: %q", d)
+	}
+}
+
+func TestEncodeResultLooksEncoded(t *testing.T) {
+	body := []byte("\x00seri:zstd:not compressed")
+	b := &Broker{}
+	v, _, err := b.encodeResult("", body, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := DecodeValue(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d, body) {
+		t.Errorf("body is changed: %q", d)
+	}
+}
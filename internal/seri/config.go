@@ -26,8 +26,55 @@ type Config struct {
 	// This will be override by `endpoints["foobar"].timeout`.
 	HTTPClientTimeout Duration `json:"http_client_timeout"`
 
+	// MaxRequestSize limits size of request body in bytes.
+	// Larger requests are rejected with "413 Request Entity Too Large".
+	// Default zero means no limit.
+	MaxRequestSize int64 `json:"max_request_size,omitempty"`
+
+	// MaxResponseSize limits size of response body from endpoints in bytes.
+	// Larger responses are truncated, and marked as "truncated".
+	// It limits decompressed body too, when it is decompressed to store.
+	// Default zero means no limit.
+	MaxResponseSize int64 `json:"max_response_size,omitempty"`
+
+	// SpoolThreshold is size in bytes, request bodies larger than this are
+	// spooled to a temporary file instead of memory.
+	// Default zero means no spooling.
+	SpoolThreshold int64 `json:"spool_threshold,omitempty"`
+
+	// SpoolDir is a directory to spool request bodies.
+	// Default empty means os.TempDir().
+	SpoolDir string `json:"spool_dir,omitempty"`
+
+	// RateLimit limits rate of incoming requests over all clients.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+
+	// ClientRateLimit limits rate of incoming requests for each client.
+	ClientRateLimit *ClientRateLimit `json:"client_rate_limit,omitempty"`
+
 	Endpoints map[string]Endpoint `json:"endpoints"`
 
+	// Compression is configuration for compression of responses.
+	Compression *Compression `json:"compression,omitempty"`
+
+	// Batch enables batching of writes of results to the store.
+	Batch *Batch `json:"batch,omitempty"`
+
+	// Degrade enables degraded mode, which buffers writes to the store while
+	// it is unavailable.
+	Degrade *Degrade `json:"degrade,omitempty"`
+
+	// HealthCheck enables health checks of the store.
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+
+	// ResultAPI enables API to list requests and get responses from the
+	// store.
+	ResultAPI *ResultAPI `json:"result_api,omitempty"`
+
+	// StoreTimeout is timeout for each operations to the store.
+	// Default zero means no timeout, but store clients may have own timeout.
+	StoreTimeout Duration `json:"store_timeout,omitempty"`
+
 	// StoreType specify store type: "none", "redis", "memcache",
 	// "binmemcache", "gocache"
 	StoreType string `json:"store_type"`
@@ -43,6 +90,15 @@ type Config struct {
 
 	// GoCache is configuration for in-process memory cache.
 	GoCache *GoCache `json:"gocache,omitempty"`
+
+	// Bolt is configuration for embedded file database.
+	Bolt *Bolt `json:"bolt,omitempty"`
+
+	// SQL is configuration for relational database.
+	SQL *SQL `json:"sql,omitempty"`
+
+	// Multi is configuration to compose several stores.
+	Multi *Multi `json:"multi,omitempty"`
 }
 
 // Clone clones a configuration object.
@@ -67,18 +123,183 @@ type Endpoint struct {
 	// Timeout provides timeout duration for each endpoints.
 	// default is Config.HTTPClientTimeout, when this is omitted.
 	Timeout Duration `json:"timeout,omitempty"`
+
+	// RateLimit limits rate of outgoing requests to this endpoint.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+}
+
+// RateLimit provides configuration of token bucket rate limitation.
+type RateLimit struct {
+	// Rate is number of tokens (requests) per second.
+	Rate float64 `json:"rate"`
+
+	// Burst is size of the bucket. Default zero means ceil of Rate.
+	Burst int `json:"burst,omitempty"`
+}
+
+// ClientRateLimit provides configuration of rate limitation for each client.
+type ClientRateLimit struct {
+	RateLimit
+
+	// KeyHeader is name of HTTP header to identify clients, like
+	// "X-API-Key". Default empty or absence of the header means IP address
+	// of clients.
+	KeyHeader string `json:"key_header,omitempty"`
+
+	// IdleTimeout is duration to forget idle clients. Default is 5 minutes.
+	IdleTimeout Duration `json:"idle_timeout,omitempty"`
+}
+
+// Batch provides configuration of batching writes of results.
+type Batch struct {
+	// Size is max number of results in a batch. Default is 100.
+	Size int `json:"size,omitempty"`
+
+	// Window is max duration to wait for results to form a batch.
+	// Default is 1ms.
+	Window Duration `json:"window,omitempty"`
+
+	// QueueSize is size of queue for results to be batched. Writes of
+	// results are blocked when the queue is full. Default is 10 times of Size.
+	QueueSize int `json:"queue_size,omitempty"`
+}
+
+// Degrade provides configuration of degraded mode. In degraded mode, writes
+// to the store are buffered while it is unavailable, and replayed in order
+// when it recovers.
+type Degrade struct {
+	// Buffer is type of buffer: "memory" (default) or "disk".
+	Buffer string `json:"buffer,omitempty"`
+
+	// Path is path of a file for "disk" buffer. Writes remained in the file
+	// are replayed at next start.
+	Path string `json:"path,omitempty"`
+
+	// MaxEntries is max number of writes in "memory" buffer. Default is
+	// 10000.
+	MaxEntries int `json:"max_entries,omitempty"`
+
+	// MaxSize is max size in bytes of the file of "disk" buffer. Default is
+	// 64MiB.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// ReplayInterval is interval to try to replay buffered writes. Default is
+	// 1s.
+	ReplayInterval Duration `json:"replay_interval,omitempty"`
+	// MaxAttempts is max number of attempts to replay a buffered write while
+	// the store is available. The write is dropped after them, to replay
+	// following writes. Default is 10.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+}
+
+// HealthCheck provides configuration of health checks of the store.
+type HealthCheck struct {
+	// OnStartupFailure is behavior when the store is unhealthy at start:
+	// "fail" (default) or "degrade" (start with unhealthy status).
+	OnStartupFailure string `json:"on_startup_failure,omitempty"`
+
+	// Interval is interval of periodic checks. Default is 10s.
+	Interval Duration `json:"interval,omitempty"`
+
+	// Timeout is timeout of each checks. Default is 1s.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// ReadyPath is path of readiness endpoint. Default is "/_ready".
+	ReadyPath string `json:"ready_path,omitempty"`
+}
+
+// ResultAPI provides configuration of API to list requests and get
+// responses.
+type ResultAPI struct {
+	// Path is base path of the API. Default is "/_results".
+	Path string `json:"path,omitempty"`
+
+	// AllowDelete enables "DELETE {path}/{id}" to delete a request from the
+	// store.
+	AllowDelete bool `json:"allow_delete,omitempty"`
+}
+
+// Compression provides configuration for compression of responses from
+// endpoints and values to store.
+type Compression struct {
+	// AcceptEncoding is list of encodings to request endpoints, "gzip" and
+	// "br" are supported.
+	AcceptEncoding []string `json:"accept_encoding,omitempty"`
+
+	// StoreEncoded stores responses which are encoded by endpoints as is,
+	// without decompression.
+	StoreEncoded bool `json:"store_encoded,omitempty"`
+
+	// Algorithm is compression algorithm for values to store: "zstd" or
+	// "gzip". Default empty means no compression.
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Threshold is minimum size in bytes of values to be compressed.
+	Threshold int `json:"threshold,omitempty"`
 }
 
 // Redis provides configuration of redis store.
 type Redis struct {
-	Addr     string   `json:"addr"`
+	Addr     string   `json:"addr,omitempty"`
+	Username string   `json:"username,omitempty"`
 	Password string   `json:"password,omitempty"`
 	DBNum    int      `json:"dbnum,omitempty"`
 	ExpireIn Duration `json:"expire_in"`
 
+	// TLS enables TLS connections to redis.
+	TLS *TLS `json:"tls,omitempty"`
+
+	// MasterName is name of master for redis sentinel. When this is set,
+	// SentinelAddrs is used instead of Addr.
+	MasterName string `json:"master_name,omitempty"`
+
+	// SentinelAddrs is addresses of redis sentinel nodes.
+	SentinelAddrs []string `json:"sentinel_addrs,omitempty"`
+
+	// SentinelPassword is password for redis sentinel nodes.
+	SentinelPassword string `json:"sentinel_password,omitempty"`
+
+	// ClusterAddrs is addresses of redis cluster nodes. When this is set,
+	// Addr and DBNum are not used.
+	ClusterAddrs []string `json:"cluster_addrs,omitempty"`
+
+	// KeyPrefix is prefix for keys of requests.
+	KeyPrefix string `json:"key_prefix,omitempty"`
+
+	// ExpireRefresh refreshes TTL of the request by each write of results.
+	// Default false keeps TTL which is set when storing the request, and
+	// sets TTL only when the key has no TTL (ex. evicted before write).
+	ExpireRefresh bool `json:"expire_refresh,omitempty"`
+
+	// Index enables an index of requests by time, to list requests. The
+	// index is a sorted set with key "{key_prefix}_index".
+	Index bool `json:"index,omitempty"`
+
+	// Codec is format of results: "json", "msgpack" or "protobuf". Values
+	// are wrapped in a versioned envelope. Default empty means the layout
+	// of older versions. Values of any codecs can be read.
+	Codec string `json:"codec,omitempty"`
+
 	// PoolSize is for size of connection pool.
 	// Default zero means 10 times of CPU number (runtime.NumCPU()).
 	PoolSize int `json:"pool_size"`
+
+	// Notify enables notifications when results are stored.
+	Notify *RedisNotify `json:"notify,omitempty"`
+}
+
+// RedisNotify provides configuration of notifications from redis store.
+type RedisNotify struct {
+	// Mode is way to notify: "publish" (PUBLISH) or "stream" (XADD).
+	Mode string `json:"mode"`
+
+	// Channel is name of channel or stream. "{reqid}" in it is replaced
+	// with request ID. Default is "serinin".
+	Channel string `json:"channel,omitempty"`
+
+	// MaxLen limits length of streams approximately (MAXLEN ~).
+	// Default zero means no limit.
+	MaxLen int64 `json:"max_len,omitempty"`
 }
 
 // Memcache provides configuration of memcache store.
@@ -86,17 +307,124 @@ type Memcache struct {
 	Addrs    []string `json:"addrs"`
 	ExpireIn Duration `json:"expire_in"`
 
+	// KeyPrefix is prefix for all keys.
+	KeyPrefix string `json:"key_prefix,omitempty"`
+
+	// KeyTemplate is template of keys for results, "{reqid}" and "{name}"
+	// in it are replaced with request ID and name of endpoint.
+	// Default is "{reqid}.{name}".
+	KeyTemplate string `json:"key_template,omitempty"`
+
+	// Codec is format of requests and results: "json", "msgpack" or
+	// "protobuf". Values are wrapped in a versioned envelope. Default empty
+	// means the layout of older versions. Values of any codecs can be read.
+	Codec string `json:"codec,omitempty"`
+
 	// MaxIdleConns limitates number of idle connections. This is available for
 	// "memcache" store only.
 	MaxIdleConns int `json:"max_idle_conns"`
 	// ConnsPerNode limitates number of connections for a node. This is
 	// available for "binmemcache" store only.
 	ConnsPerNode int `json:"conns_per_node"`
+
+	// TLS enables TLS connections to memcached. This is available for
+	// "binmemcache" store only.
+	TLS *TLS `json:"tls,omitempty"`
+
+	// Username and Password are credentials for SASL authentication
+	// (PLAIN). These are available for "binmemcache" store only.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
 }
 
-// GoCache provides configuration of go-cache store.
+// GoCache provides configuration of go-cache store. It has no "codec",
+// because records are kept as Go values in memory.
 type GoCache struct {
 	ExpireIn Duration `json:"expire_in"`
+
+	// KeyPrefix is prefix for all keys.
+	KeyPrefix string `json:"key_prefix,omitempty"`
+
+	// KeyTemplate is template of keys for results, "{reqid}" and "{name}"
+	// in it are replaced with request ID and name of endpoint.
+	// Default is "{reqid}.{name}".
+	KeyTemplate string `json:"key_template,omitempty"`
+}
+
+// Bolt provides configuration of bolt store, which persists responses to a
+// local file.
+type Bolt struct {
+	// Path is path of a database file.
+	Path string `json:"path"`
+
+	// ExpireIn is duration to keep requests and responses. Zero means to
+	// keep forever.
+	ExpireIn Duration `json:"expire_in,omitempty"`
+
+	// CompactInterval is interval to remove expired requests. Default is 1m.
+	CompactInterval Duration `json:"compact_interval,omitempty"`
+
+	// NoSync disables fsync after each commits. It improves performance, but
+	// last commits may be lost at crash of OS.
+	NoSync bool `json:"no_sync,omitempty"`
+
+	// Codec is format of results, same as "codec" of "redis".
+	Codec string `json:"codec,omitempty"`
+}
+
+// SQL provides configuration of sql store, which writes requests and results
+// to tables of relational database. It has no "codec", because fields of
+// records are stored as columns.
+type SQL struct {
+	// Driver is name of database/sql driver. Default is "sqlite3". Tables
+	// are created for "sqlite3", "sqlite", "postgres", "pgx" and "mysql",
+	// other drivers require NoCreateTables.
+	Driver string `json:"driver,omitempty"`
+
+	// DSN is data source name to connect the database.
+	DSN string `json:"dsn"`
+
+	// Placeholder is style of placeholders in queries: "?" or "$" ("$1",
+	// "$2", ...). Default is "$" for "postgres" and "pgx" drivers, otherwise
+	// "?".
+	Placeholder string `json:"placeholder,omitempty"`
+
+	// TablePrefix is prefix of names of tables: "requests" and "results".
+	TablePrefix string `json:"table_prefix,omitempty"`
+
+	// NoCreateTables disables to create tables at start.
+	NoCreateTables bool `json:"no_create_tables,omitempty"`
+
+	// MaxOpenConns is max number of connections to the database. Default is
+	// 1 for "sqlite3" driver, otherwise unlimited.
+	MaxOpenConns int `json:"max_open_conns,omitempty"`
+
+	// ExpireIn is duration to keep rows. Zero means to keep forever.
+	ExpireIn Duration `json:"expire_in,omitempty"`
+
+	// RetentionInterval is interval to delete expired rows. Default is 1m.
+	RetentionInterval Duration `json:"retention_interval,omitempty"`
+}
+
+// Multi provides configuration of multi store, which writes to several
+// stores and reads from the first one which has a request. Each store uses
+// its own configuration.
+type Multi struct {
+	// StoreTypes are types of stores in order to read. The first one is
+	// primary.
+	StoreTypes []string `json:"store_types"`
+
+	// Async makes writes to secondary stores asynchronous. Default is false,
+	// writes to all stores synchronously.
+	Async bool `json:"async,omitempty"`
+
+	// AsyncQueueSize is size of queue for asynchronous writes. Writes are
+	// dropped when the queue is full. Default is 1000.
+	AsyncQueueSize int `json:"async_queue_size,omitempty"`
+
+	// AsyncWorkers is number of workers for asynchronous writes. Default is
+	// 4.
+	AsyncWorkers int `json:"async_workers,omitempty"`
 }
 
 // LoadConfig loads a JSON file and parse as `Config`.
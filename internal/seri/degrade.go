@@ -0,0 +1,372 @@
+package seri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// degradeStorage buffers writes to the store while it is unavailable, and
+// replays them in order when it recovers.
+type degradeStorage struct {
+	Storage
+
+	buf         degradeBuffer
+	interval    time.Duration
+	timeout     time.Duration
+	maxAttempts int
+
+	// mu guards buf.
+	mu sync.Mutex
+
+	// attempts is number of failed replays of the first entry of buf. It is
+	// used only by replay.
+	attempts int
+
+	buffered int64
+	replayed int64
+	dropped  int64
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+var (
+	_ Storage      = (*degradeStorage)(nil)
+	_ Marker       = (*degradeStorage)(nil)
+	_ Completer    = (*degradeStorage)(nil)
+	_ ResultStorer = (*degradeStorage)(nil)
+	_ Pinger       = (*degradeStorage)(nil)
+	_ Lister       = (*degradeStorage)(nil)
+	_ Deleter      = (*degradeStorage)(nil)
+)
+
+// degradeEntry is a write to the store, which is buffered.
+type degradeEntry struct {
+	Op      string        `json:"op"`
+	ReqID   string        `json:"reqid"`
+	Method  string        `json:"method,omitempty"`
+	URL     string        `json:"url,omitempty"`
+	Name    string        `json:"name,omitempty"`
+	Data    []byte        `json:"data,omitempty"`
+	Mark    string        `json:"mark,omitempty"`
+	Status  int           `json:"status,omitempty"`
+	Latency time.Duration `json:"latency,omitempty"`
+
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// errUnknownOp is returned for buffered writes of unknown operations, which
+// are never replayed successfully.
+var errUnknownOp = errors.New("unknown operation of buffered write")
+
+const (
+	opRequest  = "request"
+	opResult   = "result"
+	opMark     = "mark"
+	opComplete = "complete"
+	opDelete   = "delete"
+)
+
+func (e *degradeEntry) apply(ctx context.Context, st Storage) error {
+	switch e.Op {
+	case opRequest:
+		return st.StoreRequest(ctx, e.ReqID, e.Method, e.URL)
+	case opResult:
+		return storeResult(ctx, st, e.ReqID, e.Name, e.Data, ResultInfo{Status: e.Status, Latency: e.Latency, ContentType: e.ContentType})
+	case opMark:
+		if m, ok := st.(Marker); ok {
+			return m.MarkResponse(ctx, e.ReqID, e.Name, e.Mark)
+		}
+		return nil
+	case opComplete:
+		if c, ok := st.(Completer); ok {
+			return c.CompleteRequest(ctx, e.ReqID)
+		}
+		return nil
+	case opDelete:
+		if d, ok := st.(Deleter); ok {
+			if err := d.DeleteRequest(ctx, e.ReqID); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", errUnknownOp, e.Op)
+	}
+}
+
+func newDegradeStorage(st Storage, cf *Degrade, timeout time.Duration) (*degradeStorage, error) {
+	var (
+		buf degradeBuffer
+		err error
+	)
+	switch cf.Buffer {
+	case "", "memory":
+		buf = newMemoryBuffer(cf.MaxEntries)
+	case "disk":
+		buf, err = openDiskBuffer(cf.Path, cf.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported \"buffer\" of \"degrade\": %q", cf.Buffer)
+	}
+	interval := time.Duration(cf.ReplayInterval)
+	if interval <= 0 {
+		interval = time.Second
+	}
+	maxAttempts := cf.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 10
+	}
+	ds := &degradeStorage{
+		Storage:     st,
+		buf:         buf,
+		interval:    interval,
+		timeout:     timeout,
+		maxAttempts: maxAttempts,
+		done:        make(chan struct{}),
+	}
+	if n := buf.len(); n > 0 {
+		log.Printf("[INFO] degrade: %d buffered writes are remained, to be replayed", n)
+	}
+	ds.wg.Add(1)
+	go ds.replayLoop()
+	return ds, nil
+}
+
+// write writes to the store. When the store is unavailable, or there are
+// buffered writes already, it pushes the write to the buffer to be replayed
+// later. It returns true when the write is buffered.
+func (ds *degradeStorage) write(ctx context.Context, e *degradeEntry) (bool, error) {
+	var err error
+	ds.mu.Lock()
+	if ds.buf.len() == 0 {
+		ds.mu.Unlock()
+		err = e.apply(ctx, ds.Storage)
+		// canceled writes (ex. by shutdown) are not buffered.
+		if err == nil || errors.Is(err, context.Canceled) {
+			return false, err
+		}
+		ds.mu.Lock()
+	}
+	defer ds.mu.Unlock()
+	if perr := ds.buf.push(e); perr != nil {
+		if err != nil {
+			return false, err
+		}
+		return false, perr
+	}
+	atomic.AddInt64(&ds.buffered, 1)
+	if err != nil && ds.buf.len() == 1 {
+		log.Printf("[WARN] degrade: store is unavailable, buffering writes: %s", err)
+	}
+	return true, nil
+}
+
+func (ds *degradeStorage) storeRequest(ctx context.Context, reqid, method, url string) (bool, error) {
+	return ds.write(ctx, &degradeEntry{Op: opRequest, ReqID: reqid, Method: method, URL: url})
+}
+
+func (ds *degradeStorage) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	_, err := ds.storeRequest(ctx, reqid, method, url)
+	return err
+}
+
+func (ds *degradeStorage) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return ds.StoreResult(ctx, reqid, name, data, ResultInfo{})
+}
+
+func (ds *degradeStorage) StoreResult(ctx context.Context, reqid, name string, data []byte, info ResultInfo) error {
+	_, err := ds.write(ctx, &degradeEntry{Op: opResult, ReqID: reqid, Name: name, Data: data, Status: info.Status, Latency: info.Latency, ContentType: info.ContentType})
+	return err
+}
+
+func (ds *degradeStorage) MarkResponse(ctx context.Context, reqid, name, mark string) error {
+	_, err := ds.write(ctx, &degradeEntry{Op: opMark, ReqID: reqid, Name: name, Mark: mark})
+	return err
+}
+
+func (ds *degradeStorage) CompleteRequest(ctx context.Context, reqid string) error {
+	_, err := ds.write(ctx, &degradeEntry{Op: opComplete, ReqID: reqid})
+	return err
+}
+
+// DeleteRequest deletes a request from the store. When there are buffered
+// writes, it is buffered to be applied after them.
+func (ds *degradeStorage) DeleteRequest(ctx context.Context, reqid string) error {
+	d, ok := ds.Storage.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	ds.mu.Lock()
+	if ds.buf.len() > 0 {
+		defer ds.mu.Unlock()
+		if err := ds.buf.push(&degradeEntry{Op: opDelete, ReqID: reqid}); err != nil {
+			return err
+		}
+		atomic.AddInt64(&ds.buffered, 1)
+		return nil
+	}
+	ds.mu.Unlock()
+	return d.DeleteRequest(ctx, reqid)
+}
+
+func (ds *degradeStorage) Ping(ctx context.Context) error {
+	if p, ok := ds.Storage.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (ds *degradeStorage) ListRequests(ctx context.Context, opts *ListOptions) ([]*RequestInfo, error) {
+	if l, ok := ds.Storage.(Lister); ok {
+		return l.ListRequests(ctx, opts)
+	}
+	return nil, ErrNotSupported
+}
+
+func (ds *degradeStorage) replayLoop() {
+	defer ds.wg.Done()
+	tk := time.NewTicker(ds.interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ds.done:
+			return
+		case <-tk.C:
+			ds.replay(ds.done)
+		}
+	}
+}
+
+// isPermanent checks an error of a replayed write, whether retries of the
+// write never succeed.
+func isPermanent(err error) bool {
+	return errors.Is(err, errUnknownOp) || errors.Is(err, ErrNotSupported)
+}
+
+// isAvailable checks the store is available, to tell failures of a write from
+// failures of the store.
+func (ds *degradeStorage) isAvailable() bool {
+	p, ok := ds.Storage.(Pinger)
+	if !ok {
+		return true
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if ds.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), ds.timeout)
+	}
+	defer cancel()
+	return p.Ping(ctx) == nil
+}
+
+// shouldDrop checks a failed replay of the first entry, whether to give it
+// up. A write is dropped when its error is permanent, or it fails
+// "max_attempts" times while the store is available. Failures while the store
+// is unavailable are transient, they are retried without limit.
+func (ds *degradeStorage) shouldDrop(err error) bool {
+	if isPermanent(err) {
+		return true
+	}
+	if !ds.isAvailable() {
+		return false
+	}
+	ds.attempts++
+	return ds.attempts >= ds.maxAttempts
+}
+
+// replay replays buffered writes in order, until the buffer gets empty, a
+// write fails or stop is closed. A write which fails permanently is dropped,
+// to replay following writes.
+func (ds *degradeStorage) replay(stop <-chan struct{}) error {
+	n := 0
+	for {
+		ds.mu.Lock()
+		e, err := ds.buf.peek()
+		ds.mu.Unlock()
+		if err != nil {
+			log.Printf("[ERROR] degrade: failed to read buffer: %s", err)
+			return err
+		}
+		if e == nil {
+			return nil
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		if ds.timeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), ds.timeout)
+		}
+		err = e.apply(ctx, ds.Storage)
+		cancel()
+		drop := false
+		if err != nil {
+			if !ds.shouldDrop(err) {
+				return err
+			}
+			drop = true
+			log.Printf("[ERROR] degrade: drop a buffered write which can't be replayed: op=%s reqid=%s name=%s: %s", e.Op, e.ReqID, e.Name, err)
+		}
+		ds.mu.Lock()
+		err = ds.buf.pop()
+		remain := ds.buf.len()
+		ds.mu.Unlock()
+		if err != nil {
+			log.Printf("[ERROR] degrade: failed to update buffer: %s", err)
+			return err
+		}
+		ds.attempts = 0
+		if drop {
+			atomic.AddInt64(&ds.dropped, 1)
+		} else {
+			n++
+			atomic.AddInt64(&ds.replayed, 1)
+		}
+		if remain == 0 {
+			log.Printf("[INFO] degrade: store is recovered, %d buffered writes are replayed", n)
+			return nil
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+	}
+}
+
+// Close replays buffered writes once, then closes the buffer and the
+// underlying Storage. Remained writes in "disk" buffer are replayed at next
+// start.
+func (ds *degradeStorage) Close() error {
+	var err error
+	ds.closeOnce.Do(func() {
+		close(ds.done)
+		ds.wg.Wait()
+		ds.replay(nil)
+		if n := ds.buf.len(); n > 0 {
+			log.Printf("[WARN] degrade: %d buffered writes are not replayed", n)
+		}
+		err = ds.buf.close()
+		if c, ok := ds.Storage.(io.Closer); ok {
+			if err2 := c.Close(); err == nil {
+				err = err2
+			}
+		}
+	})
+	return err
+}
+
+// storeRequest stores a request. It returns true when the request is
+// buffered by degraded mode.
+func (b *Broker) storeRequest(ctx context.Context, reqid, method, url string) (bool, error) {
+	if ds, ok := b.st.(*degradeStorage); ok {
+		return ds.storeRequest(ctx, reqid, method, url)
+	}
+	return false, b.st.StoreRequest(ctx, reqid, method, url)
+}
@@ -0,0 +1,140 @@
+package seri
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failStore is a fake Storage, which fails to store results of "bad"
+// request, and fails all writes while it is down.
+type failStore struct {
+	mu      sync.Mutex
+	down    bool
+	results []string
+}
+
+var errFailStore = errors.New("failStore: failed")
+
+func (fs *failStore) setDown(down bool) {
+	fs.mu.Lock()
+	fs.down = down
+	fs.mu.Unlock()
+}
+
+func (fs *failStore) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	return nil
+}
+
+func (fs *failStore) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.down || reqid == "bad" {
+		return errFailStore
+	}
+	fs.results = append(fs.results, reqid)
+	return nil
+}
+
+func (fs *failStore) GetResponse(ctx context.Context, reqid string) (*Response, error) {
+	return nil, ErrNotFound
+}
+
+func (fs *failStore) Ping(ctx context.Context) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.down {
+		return errFailStore
+	}
+	return nil
+}
+
+func newTestDegradeStorage(t *testing.T, st Storage, maxAttempts int) *degradeStorage {
+	t.Helper()
+	ds, err := newDegradeStorage(st, &Degrade{
+		ReplayInterval: Duration(time.Hour),
+		MaxAttempts:    maxAttempts,
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ds.Close() })
+	return ds
+}
+
+func pushResults(t *testing.T, ds *degradeStorage, reqids ...string) {
+	t.Helper()
+	for _, id := range reqids {
+		if err := ds.buf.push(&degradeEntry{Op: opResult, ReqID: id, Name: "ep1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReplayDropsFailingWrite(t *testing.T) {
+	fs := &failStore{}
+	ds := newTestDegradeStorage(t, fs, 3)
+	pushResults(t, ds, "r1", "bad", "r2")
+
+	for i := 0; i < 2; i++ {
+		if err := ds.replay(nil); !errors.Is(err, errFailStore) {
+			t.Fatalf("replay #%d should fail: %v", i, err)
+		}
+		if n := ds.buf.len(); n != 2 {
+			t.Fatalf("failed write is dropped too early: remain=%d", n)
+		}
+	}
+	if err := ds.replay(nil); err != nil {
+		t.Fatalf("replay should succeed after dropping: %s", err)
+	}
+	if n := ds.buf.len(); n != 0 {
+		t.Errorf("writes are remained: %d", n)
+	}
+	if len(fs.results) != 2 || fs.results[0] != "r1" || fs.results[1] != "r2" {
+		t.Errorf("unexpected replayed results: %v", fs.results)
+	}
+	if ds.dropped != 1 || ds.replayed != 2 {
+		t.Errorf("unexpected stats: dropped=%d replayed=%d", ds.dropped, ds.replayed)
+	}
+}
+
+func TestReplayKeepsWhileStoreIsDown(t *testing.T) {
+	fs := &failStore{down: true}
+	ds := newTestDegradeStorage(t, fs, 2)
+	pushResults(t, ds, "r1", "r2")
+
+	for i := 0; i < 5; i++ {
+		if err := ds.replay(nil); !errors.Is(err, errFailStore) {
+			t.Fatalf("replay #%d should fail: %v", i, err)
+		}
+	}
+	if n := ds.buf.len(); n != 2 || ds.dropped != 0 {
+		t.Fatalf("writes are dropped while the store is down: remain=%d dropped=%d", n, ds.dropped)
+	}
+
+	fs.setDown(false)
+	if err := ds.replay(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(fs.results) != 2 || ds.replayed != 2 {
+		t.Errorf("unexpected replayed results: %v", fs.results)
+	}
+}
+
+func TestReplayDropsPermanentError(t *testing.T) {
+	fs := &failStore{}
+	ds := newTestDegradeStorage(t, fs, 10)
+	if err := ds.buf.push(&degradeEntry{Op: "unknown", ReqID: "r0"}); err != nil {
+		t.Fatal(err)
+	}
+	pushResults(t, ds, "r1")
+
+	if err := ds.replay(nil); err != nil {
+		t.Fatalf("unknown operation should be dropped at once: %s", err)
+	}
+	if ds.dropped != 1 || ds.replayed != 1 {
+		t.Errorf("unexpected stats: dropped=%d replayed=%d", ds.dropped, ds.replayed)
+	}
+}
@@ -0,0 +1,226 @@
+package seri
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+// errDegradeBufferFull is returned when the buffer of degraded mode is full.
+var errDegradeBufferFull = errors.New("buffer of degraded mode is full")
+
+// degradeBuffer is a FIFO queue of buffered writes.
+type degradeBuffer interface {
+	push(e *degradeEntry) error
+
+	// peek returns the first entry, or nil when the buffer is empty.
+	peek() (*degradeEntry, error)
+
+	// pop removes the first entry.
+	pop() error
+
+	len() int
+
+	close() error
+}
+
+// memoryBuffer is a degradeBuffer on memory.
+type memoryBuffer struct {
+	max     int
+	entries []*degradeEntry
+}
+
+func newMemoryBuffer(max int) *memoryBuffer {
+	if max <= 0 {
+		max = 10000
+	}
+	return &memoryBuffer{max: max}
+}
+
+func (mb *memoryBuffer) push(e *degradeEntry) error {
+	if len(mb.entries) >= mb.max {
+		return errDegradeBufferFull
+	}
+	mb.entries = append(mb.entries, e)
+	return nil
+}
+
+func (mb *memoryBuffer) peek() (*degradeEntry, error) {
+	if len(mb.entries) == 0 {
+		return nil, nil
+	}
+	return mb.entries[0], nil
+}
+
+func (mb *memoryBuffer) pop() error {
+	if len(mb.entries) == 0 {
+		return nil
+	}
+	mb.entries[0] = nil
+	mb.entries = mb.entries[1:]
+	if len(mb.entries) == 0 {
+		mb.entries = nil
+	}
+	return nil
+}
+
+func (mb *memoryBuffer) len() int {
+	return len(mb.entries)
+}
+
+func (mb *memoryBuffer) close() error {
+	mb.entries = nil
+	return nil
+}
+
+// diskBuffer is a degradeBuffer on a file. Entries are appended to the file
+// as JSON lines, and the file is truncated when all entries are popped.
+type diskBuffer struct {
+	max int64
+
+	w    *os.File
+	rf   *os.File
+	r    *bufio.Reader
+	size int64
+	n    int
+
+	head *degradeEntry
+}
+
+func openDiskBuffer(path string, max int64) (*diskBuffer, error) {
+	if path == "" {
+		return nil, errors.New("\"path\" of \"degrade\" is required for \"disk\" buffer")
+	}
+	if max <= 0 {
+		max = 64 * 1024 * 1024
+	}
+	w, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := os.Open(path)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	db := &diskBuffer{max: max, w: w, rf: rf, r: bufio.NewReader(rf)}
+	if err := db.load(); err != nil {
+		db.close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// load counts entries which remained in the file. A broken last line is
+// removed.
+func (db *diskBuffer) load() error {
+	var size int64
+	r := bufio.NewReader(db.rf)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		size += int64(len(line))
+		if len(bytes.TrimSpace(line)) > 0 {
+			db.n++
+		}
+	}
+	if err := db.w.Truncate(size); err != nil {
+		return err
+	}
+	db.size = size
+	if _, err := db.rf.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	db.r.Reset(db.rf)
+	return nil
+}
+
+func (db *diskBuffer) push(e *degradeEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if db.size+int64(len(b)) > db.max {
+		return errDegradeBufferFull
+	}
+	if _, err := db.w.Write(b); err != nil {
+		return err
+	}
+	db.size += int64(len(b))
+	db.n++
+	return nil
+}
+
+func (db *diskBuffer) peek() (*degradeEntry, error) {
+	if db.head != nil {
+		return db.head, nil
+	}
+	for db.n > 0 {
+		line, err := db.r.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		e := &degradeEntry{}
+		if err := json.Unmarshal(line, e); err != nil {
+			log.Printf("[WARN] degrade: skip a broken buffered write: %s", err)
+			db.n--
+			continue
+		}
+		db.head = e
+		return e, nil
+	}
+	return nil, db.reset()
+}
+
+func (db *diskBuffer) pop() error {
+	if db.n == 0 {
+		return nil
+	}
+	db.head = nil
+	db.n--
+	if db.n > 0 {
+		return nil
+	}
+	return db.reset()
+}
+
+// reset truncates the file when it has no entries.
+func (db *diskBuffer) reset() error {
+	if db.size == 0 {
+		return nil
+	}
+	if err := db.w.Truncate(0); err != nil {
+		return err
+	}
+	db.size = 0
+	if _, err := db.rf.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	db.r.Reset(db.rf)
+	return nil
+}
+
+func (db *diskBuffer) len() int {
+	return db.n
+}
+
+func (db *diskBuffer) close() error {
+	err := db.w.Close()
+	if err2 := db.rf.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package seri
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// health holds health status of the store, which is checked with Pinger.
+type health struct {
+	mu        sync.RWMutex
+	err       error
+	checkedAt time.Time
+
+	wg sync.WaitGroup
+}
+
+func (hc *HealthCheck) interval() time.Duration {
+	if d := time.Duration(hc.Interval); d > 0 {
+		return d
+	}
+	return 10 * time.Second
+}
+
+func (hc *HealthCheck) timeout() time.Duration {
+	if d := time.Duration(hc.Timeout); d > 0 {
+		return d
+	}
+	return time.Second
+}
+
+func (hc *HealthCheck) readyPath() string {
+	if hc.ReadyPath != "" {
+		return hc.ReadyPath
+	}
+	return "/_ready"
+}
+
+// checkHealth pings the store and updates health status. Stores which don't
+// implement Pinger are always healthy.
+func (b *Broker) checkHealth(ctx context.Context) error {
+	var err error
+	if p, ok := b.st.(Pinger); ok {
+		ctx, cancel := context.WithTimeout(ctx, b.cf.HealthCheck.timeout())
+		err = p.Ping(ctx)
+		cancel()
+	}
+	b.health.mu.Lock()
+	prev := b.health.err
+	b.health.err = err
+	b.health.checkedAt = time.Now()
+	b.health.mu.Unlock()
+	switch {
+	case err != nil && prev == nil:
+		b.log.Printf("[WARN] broker: store is unhealthy: %s", err)
+	case err == nil && prev != nil:
+		b.log.Printf("[INFO] broker: store is recovered")
+	}
+	return err
+}
+
+func (b *Broker) startHealthCheck() {
+	b.health.wg.Add(1)
+	go func() {
+		defer b.health.wg.Done()
+		tk := time.NewTicker(b.cf.HealthCheck.interval())
+		defer tk.Stop()
+		for {
+			select {
+			case <-b.ctx.Done():
+				return
+			case <-tk.C:
+				b.checkHealth(b.ctx)
+			}
+		}
+	}()
+}
+
+type readiness struct {
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// readyHandler wraps a handler to serve the readiness endpoint on
+// "ready_path", when "health_check" is configured.
+func (b *Broker) readyHandler(h http.Handler) http.Handler {
+	hc := b.cf.HealthCheck
+	if hc == nil {
+		return h
+	}
+	path := hc.readyPath()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			h.ServeHTTP(w, r)
+			return
+		}
+		b.serveReady(w, r)
+	})
+}
+
+// serveReady responds "200 OK" when the broker is ready to accept requests,
+// otherwise "503 Service Unavailable".
+func (b *Broker) serveReady(w http.ResponseWriter, r *http.Request) {
+	b.health.mu.RLock()
+	err := b.health.err
+	at := b.health.checkedAt
+	b.health.mu.RUnlock()
+	b.mu.RLock()
+	closing := b.closing
+	b.mu.RUnlock()
+
+	rd := &readiness{Status: "ok"}
+	if !at.IsZero() {
+		rd.CheckedAt = &at
+	}
+	code := http.StatusOK
+	switch {
+	case closing:
+		rd.Status = "shutting down"
+		code = http.StatusServiceUnavailable
+	case err != nil:
+		rd.Status = "unhealthy"
+		rd.Error = err.Error()
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		json.NewEncoder(w).Encode(rd)
+	}
+}
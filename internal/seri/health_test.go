@@ -0,0 +1,169 @@
+package seri_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+// pingStore is a store which reports health with err.
+type pingStore struct {
+	seri.Storage
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+func (ps *pingStore) Ping(ctx context.Context) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.err
+}
+
+func (ps *pingStore) setErr(err error) {
+	ps.mu.Lock()
+	ps.err = err
+	ps.mu.Unlock()
+}
+
+func (ps *pingStore) Close() error {
+	ps.mu.Lock()
+	ps.closed = true
+	ps.mu.Unlock()
+	return nil
+}
+
+func newPingStore(t *testing.T, cf *seri.Config, err error) *pingStore {
+	t.Helper()
+	cf.StoreType = "gocache"
+	cf.GoCache = &seri.GoCache{ExpireIn: seri.Duration(time.Minute)}
+	st, err2 := seri.NewStorage(cf)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	return &pingStore{Storage: st, err: err}
+}
+
+// ready gets the readiness endpoint.
+func ready(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url + "/_ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var v struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, v.Status
+}
+
+// waitReady polls the readiness endpoint until it returns code.
+func waitReady(t *testing.T, url string, code int, status string) {
+	t.Helper()
+	var (
+		gotCode   int
+		gotStatus string
+	)
+	for i := 0; i < 300; i++ {
+		gotCode, gotStatus = ready(t, url)
+		if gotCode == code && gotStatus == status {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("unexpected readiness: want=%d %q got=%d %q", code, status, gotCode, gotStatus)
+}
+
+func TestStartupFailure(t *testing.T) {
+	for _, mode := range []string{"", "fail"} {
+		cf := &seri.Config{
+			Endpoints:   map[string]seri.Endpoint{"ep1": {URL: textEndpoint(t, "hello", 0)}},
+			HealthCheck: &seri.HealthCheck{OnStartupFailure: mode},
+		}
+		ps := newPingStore(t, cf, errors.New("connection refused"))
+		_, err := seri.NewBroker(cf, seri.WithStorage(ps))
+		if err == nil || !strings.Contains(err.Error(), "connection refused") {
+			t.Errorf("%q: unexpected error: %v", mode, err)
+		}
+		if !ps.closed {
+			t.Errorf("%q: store is not closed", mode)
+		}
+	}
+}
+
+func TestStartupDegrade(t *testing.T) {
+	cf := &seri.Config{
+		Endpoints: map[string]seri.Endpoint{"ep1": {URL: textEndpoint(t, "hello", 0)}},
+		HealthCheck: &seri.HealthCheck{
+			OnStartupFailure: "degrade",
+			Interval:         seri.Duration(10 * time.Millisecond),
+		},
+	}
+	ps := newPingStore(t, cf, errors.New("connection refused"))
+	b, err := seri.NewBroker(cf, seri.WithStorage(ps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	ts := httptest.NewServer(b.Handler())
+	defer ts.Close()
+
+	if code, status := ready(t, ts.URL); code != http.StatusServiceUnavailable || status != "unhealthy" {
+		t.Errorf("unexpected readiness at start: %d %q", code, status)
+	}
+	// it becomes ready when the store is recovered.
+	ps.setErr(nil)
+	waitReady(t, ts.URL, http.StatusOK, "ok")
+}
+
+func TestReady(t *testing.T) {
+	cf := &seri.Config{
+		Endpoints: map[string]seri.Endpoint{"stuck": {URL: blockingEndpoint(t)}},
+		HealthCheck: &seri.HealthCheck{
+			Interval: seri.Duration(10 * time.Millisecond),
+		},
+	}
+	ps := newPingStore(t, cf, nil)
+	b, err := seri.NewBroker(cf, seri.WithStorage(ps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	ts := httptest.NewServer(b.Handler())
+	defer ts.Close()
+
+	if code, status := ready(t, ts.URL); code != http.StatusOK || status != "ok" {
+		t.Errorf("unexpected readiness at start: %d %q", code, status)
+	}
+	ps.setErr(errors.New("connection refused"))
+	waitReady(t, ts.URL, http.StatusServiceUnavailable, "unhealthy")
+	ps.setErr(nil)
+	waitReady(t, ts.URL, http.StatusOK, "ok")
+
+	// an inquiry in flight keeps the broker shutting down.
+	if code, _ := send(t, "GET", ts.URL, ""); code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", code)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Shutdown(ctx)
+		close(done)
+	}()
+	waitReady(t, ts.URL, http.StatusServiceUnavailable, "shutting down")
+	cancel()
+	<-done
+}
@@ -0,0 +1,67 @@
+package seri
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MarkPrefix is prefix of names to store marks of endpoints.
+const MarkPrefix = "_mark."
+
+// reservedNames are names which are used by stores for information of
+// requests.
+var reservedNames = map[string]struct{}{
+	"_id":     {},
+	"_method": {},
+	"_url":    {},
+}
+
+// IsReservedName checks a name is reserved by stores, so it can't be used as
+// name of an endpoint.
+func IsReservedName(name string) bool {
+	if _, ok := reservedNames[name]; ok {
+		return true
+	}
+	return strings.HasPrefix(name, MarkPrefix)
+}
+
+// DefaultKeyTemplate is default template of keys for results.
+const DefaultKeyTemplate = "{reqid}.{name}"
+
+// Keys generates keys for stores, with "key_prefix" and "key_template".
+type Keys struct {
+	prefix   string
+	template string
+}
+
+// NewKeys creates a new Keys. template must include both "{reqid}" and
+// "{name}", empty means DefaultKeyTemplate.
+func NewKeys(prefix, template string) (*Keys, error) {
+	if template == "" {
+		template = DefaultKeyTemplate
+	}
+	if !strings.Contains(template, "{reqid}") || !strings.Contains(template, "{name}") {
+		return nil, fmt.Errorf("\"key_template\" must include {reqid} and {name}: %q", template)
+	}
+	if strings.ContainsAny(prefix+template, " \t\r\n") {
+		return nil, errors.New("\"key_prefix\" and \"key_template\" can't include spaces")
+	}
+	return &Keys{prefix: prefix, template: template}, nil
+}
+
+// Request returns a key for a request.
+func (k *Keys) Request(reqid string) string {
+	return k.prefix + reqid
+}
+
+// Result returns a key for a result of an endpoint.
+func (k *Keys) Result(reqid, name string) string {
+	r := strings.NewReplacer("{reqid}", reqid, "{name}", name)
+	return k.prefix + r.Replace(k.template)
+}
+
+// Mark returns a key for a mark of an endpoint.
+func (k *Keys) Mark(reqid, name string) string {
+	return k.Result(reqid, MarkPrefix+name)
+}
@@ -0,0 +1,114 @@
+package seri
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Storage when a request is not found.
+var ErrNotFound = errors.New("no requests found")
+
+// ErrNotSupported is returned when an optional capability is not supported
+// by the store.
+var ErrNotSupported = errors.New("not supported by the store")
+
+// DefaultListLimit is default number of requests to be listed.
+const DefaultListLimit = 100
+
+// Lister is an optional capability of Storage, to list recent requests.
+type Lister interface {
+	// ListRequests lists requests which match with opts, in order from
+	// newest.
+	ListRequests(ctx context.Context, opts *ListOptions) ([]*RequestInfo, error)
+}
+
+// ListOptions is filters to list requests.
+type ListOptions struct {
+	// Since and Until limit time range of requests: Since <= t < Until.
+	// Zero means no limitation.
+	Since time.Time
+	Until time.Time
+
+	// URLPrefix limits URL of requests by prefix.
+	URLPrefix string
+
+	// Method limits method of requests.
+	Method string
+
+	// Missing limits requests to ones which lack results of any of these
+	// endpoints.
+	Missing []string
+
+	// Limit is max number of requests. Default is DefaultListLimit.
+	Limit int
+}
+
+// RequestInfo is summary of a request, for listing.
+type RequestInfo struct {
+	ID        string    `json:"id"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Results are names of endpoints which have results.
+	Results []string `json:"results"`
+}
+
+// MaxCount returns max number of requests to be listed.
+func (o *ListOptions) MaxCount() int {
+	if o.Limit > 0 {
+		return o.Limit
+	}
+	return DefaultListLimit
+}
+
+// InRange checks t is in time range of the options.
+func (o *ListOptions) InRange(t time.Time) bool {
+	if !o.Since.IsZero() && t.Before(o.Since) {
+		return false
+	}
+	if !o.Until.IsZero() && !t.Before(o.Until) {
+		return false
+	}
+	return true
+}
+
+// Match checks a request matches with all filters of the options.
+func (o *ListOptions) Match(ri *RequestInfo) bool {
+	if !o.InRange(ri.CreatedAt) {
+		return false
+	}
+	if o.URLPrefix != "" && !strings.HasPrefix(ri.URL, o.URLPrefix) {
+		return false
+	}
+	if o.Method != "" && !strings.EqualFold(ri.Method, o.Method) {
+		return false
+	}
+	if len(o.Missing) == 0 {
+		return true
+	}
+	for _, name := range o.Missing {
+		found := false
+		for _, s := range ri.Results {
+			if s == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTime parses time for filters: RFC3339 format, or duration before now
+// (ex. "10m").
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
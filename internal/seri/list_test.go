@@ -0,0 +1,80 @@
+package seri
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseListOptions(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		query string
+		want  *ListOptions
+	}{
+		{"", &ListOptions{}},
+		{"url=/foo&method=POST&limit=10", &ListOptions{URLPrefix: "/foo", Method: "POST", Limit: 10}},
+		{"since=10m&until=2024-01-02T00:00:00Z", &ListOptions{
+			Since: now.Add(-10 * time.Minute),
+			Until: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		}},
+		{"missing=a,b", &ListOptions{Missing: []string{"a", "b"}}},
+	} {
+		q, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseListOptions(q, now)
+		if err != nil {
+			t.Errorf("%q: %s", tc.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: unexpected options: want=%+v got=%+v", tc.query, tc.want, got)
+		}
+	}
+
+	for _, query := range []string{"since=yesterday", "until=1d", "limit=x", "limit=-1"} {
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseListOptions(q, now); err == nil {
+			t.Errorf("%q: should fail", query)
+		}
+	}
+}
+
+func TestListOptionsMatch(t *testing.T) {
+	now := time.Now()
+	ri := &RequestInfo{
+		ID:        "r1",
+		Method:    "GET",
+		URL:       "/foo/bar",
+		CreatedAt: now,
+		Results:   []string{"a", "b"},
+	}
+	for _, tc := range []struct {
+		opts *ListOptions
+		want bool
+	}{
+		{&ListOptions{}, true},
+		{&ListOptions{Since: now}, true},
+		{&ListOptions{Since: now.Add(time.Second)}, false},
+		{&ListOptions{Until: now.Add(time.Second)}, true},
+		{&ListOptions{Until: now}, false},
+		{&ListOptions{URLPrefix: "/foo/"}, true},
+		{&ListOptions{URLPrefix: "/bar"}, false},
+		{&ListOptions{Method: "get"}, true},
+		{&ListOptions{Method: "POST"}, false},
+		// requests which lack results of any of endpoints.
+		{&ListOptions{Missing: []string{"a"}}, false},
+		{&ListOptions{Missing: []string{"a", "b"}}, false},
+		{&ListOptions{Missing: []string{"a", "c"}}, true},
+	} {
+		if got := tc.opts.Match(ri); got != tc.want {
+			t.Errorf("unexpected match for %+v: want=%t got=%t", tc.opts, tc.want, got)
+		}
+	}
+}
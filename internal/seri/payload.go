@@ -0,0 +1,76 @@
+package seri
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// payload is a request body to be replayed to each endpoints. Large one is
+// spooled to a temporary file.
+type payload struct {
+	data []byte
+
+	file *os.File
+	size int64
+}
+
+// readPayload reads whole body of a request, with limitation of
+// "max_request_size". The body is spooled to a temporary file when it is
+// larger than "spool_threshold".
+func (b *Broker) readPayload(w http.ResponseWriter, r *http.Request) (*payload, error) {
+	body := r.Body
+	if max := b.cf.MaxRequestSize; max > 0 {
+		body = http.MaxBytesReader(w, body, max)
+	}
+	th := b.cf.SpoolThreshold
+	if th <= 0 {
+		d, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		return &payload{data: d}, nil
+	}
+
+	bb := &bytes.Buffer{}
+	_, err := io.CopyN(bb, body, th+1)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return &payload{data: bb.Bytes()}, nil
+		}
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(b.cf.SpoolDir, "serinin-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(f, io.MultiReader(bb, body))
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &payload{file: f, size: n}, nil
+}
+
+// reader returns a new reader of the payload. It is safe to be called
+// concurrently.
+func (p *payload) reader() io.Reader {
+	if p.file != nil {
+		return io.NewSectionReader(p.file, 0, p.size)
+	}
+	return bytes.NewReader(p.data)
+}
+
+// close removes the spooled file.
+func (p *payload) close() {
+	if p.file == nil {
+		return
+	}
+	p.file.Close()
+	os.Remove(p.file.Name())
+}
@@ -0,0 +1,139 @@
+package seri_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+// echoEndpoint returns an endpoint which responds the request body. fn is
+// called with each request before responding, if not nil.
+func echoEndpoint(t *testing.T, fn func(r *http.Request)) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fn != nil {
+			fn(r)
+		}
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestMaxRequestSize(t *testing.T) {
+	_, _, ts := newTestBroker(t, &seri.Config{
+		MaxRequestSize: 10,
+		Endpoints:      map[string]seri.Endpoint{"ep1": {URL: echoEndpoint(t, nil)}},
+	})
+	if code, _ := send(t, "POST", ts.URL, strings.Repeat("x", 10)); code != http.StatusOK {
+		t.Errorf("request within the limit is rejected: %d", code)
+	}
+	if code, _ := send(t, "POST", ts.URL, strings.Repeat("x", 11)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected status for a large request: %d", code)
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	_, st, ts := newTestBroker(t, &seri.Config{
+		MaxResponseSize: 10,
+		Endpoints: map[string]seri.Endpoint{
+			"large": {URL: textEndpoint(t, strings.Repeat("x", 100), 0)},
+			"small": {URL: textEndpoint(t, "small", 0)},
+		},
+	})
+	_, id := send(t, "GET", ts.URL, "")
+	r := waitResponse(t, st, id, func(r *seri.Response) bool {
+		return len(r.Results) == 2 && r.Marks["large"] != ""
+	})
+	if got := string(r.Results["large"].Data); got != strings.Repeat("x", 10) {
+		t.Errorf("response is not truncated: %q", got)
+	}
+	if got := r.Marks["large"]; got != seri.MarkTruncated {
+		t.Errorf("unexpected mark: %q", got)
+	}
+	if got := string(r.Results["small"].Data); got != "small" || r.Marks["small"] != "" {
+		t.Errorf("small response is changed: %q %q", got, r.Marks["small"])
+	}
+}
+
+func spooledFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "serinin-spool-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		mu      sync.Mutex
+		spooled []int
+	)
+	ep := echoEndpoint(t, func(r *http.Request) {
+		mu.Lock()
+		spooled = append(spooled, len(spooledFiles(t, dir)))
+		mu.Unlock()
+	})
+	_, st, ts := newTestBroker(t, &seri.Config{
+		SpoolThreshold: 16,
+		SpoolDir:       dir,
+		Endpoints:      map[string]seri.Endpoint{"ep1": {URL: ep}},
+	})
+	for _, body := range []string{"small", strings.Repeat("large", 10)} {
+		mu.Lock()
+		spooled = nil
+		mu.Unlock()
+		_, id := send(t, "POST", ts.URL, body)
+		r := waitResponse(t, st, id, hasResults(1))
+		if got := string(r.Results["ep1"].Data); got != body {
+			t.Errorf("body is not forwarded: want=%q got=%q", body, got)
+		}
+		want := 0
+		if len(body) > 16 {
+			want = 1
+		}
+		mu.Lock()
+		if len(spooled) != 1 || spooled[0] != want {
+			t.Errorf("unexpected spooled files for %d bytes: %v", len(body), spooled)
+		}
+		mu.Unlock()
+	}
+	// spooled files are removed after inquiries.
+	for i := 0; len(spooledFiles(t, dir)) > 0; i++ {
+		if i >= 100 {
+			t.Fatalf("spooled files are not removed: %v", spooledFiles(t, dir))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestSpoolRedirect checks a spooled body is sent again when an endpoint
+// redirects with 307.
+func TestSpoolRedirect(t *testing.T) {
+	echo := echoEndpoint(t, nil)
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, echo, http.StatusTemporaryRedirect)
+	}))
+	defer redirect.Close()
+	_, st, ts := newTestBroker(t, &seri.Config{
+		SpoolThreshold: 16,
+		SpoolDir:       t.TempDir(),
+		Endpoints:      map[string]seri.Endpoint{"ep1": {URL: redirect.URL}},
+	})
+	body := strings.Repeat("large", 10)
+	_, id := send(t, "POST", ts.URL, body)
+	r := waitResponse(t, st, id, hasResults(1))
+	if got := r.Results["ep1"].Data; !bytes.Equal(got, []byte(body)) {
+		t.Errorf("body is not sent to the redirected endpoint: %q", got)
+	}
+}
@@ -0,0 +1,170 @@
+package seri
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func newLimiter(rl *RateLimit) *rate.Limiter {
+	if rl == nil || rl.Rate <= 0 {
+		return nil
+	}
+	burst := rl.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(rl.Rate))
+	}
+	return rate.NewLimiter(rate.Limit(rl.Rate), burst)
+}
+
+// reserve tries to take a token from the limiter. It returns the reservation
+// when a token is available now, to cancel it later. Otherwise it returns the
+// duration to wait for next token.
+func reserve(l *rate.Limiter, now time.Time) (*rate.Reservation, time.Duration) {
+	r := l.ReserveN(now, 1)
+	if !r.OK() {
+		return nil, time.Second
+	}
+	d := r.DelayFrom(now)
+	if d > 0 {
+		r.CancelAt(now)
+		return nil, d
+	}
+	return r, 0
+}
+
+// clientLimiter limits rate of requests for each client.
+type clientLimiter struct {
+	cf *ClientRateLimit
+
+	mu        sync.Mutex
+	limiters  map[string]*clientEntry
+	lastSweep time.Time
+}
+
+type clientEntry struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+func newClientLimiter(cf *ClientRateLimit) *clientLimiter {
+	if cf == nil || cf.Rate <= 0 {
+		return nil
+	}
+	return &clientLimiter{
+		cf:       cf,
+		limiters: make(map[string]*clientEntry),
+	}
+}
+
+func (cl *clientLimiter) idleTimeout() time.Duration {
+	if d := time.Duration(cl.cf.IdleTimeout); d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
+
+// clientKey determines key of a client: a value of the header specified by
+// "key_header" or IP address of the client.
+func (cl *clientLimiter) clientKey(r *http.Request) string {
+	if h := cl.cf.KeyHeader; h != "" {
+		if v := r.Header.Get(h); v != "" {
+			return h + ":" + v
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (cl *clientLimiter) reserve(r *http.Request, now time.Time) (*rate.Reservation, time.Duration) {
+	key := cl.clientKey(r)
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	idle := cl.idleTimeout()
+	if now.Sub(cl.lastSweep) > idle {
+		for k, e := range cl.limiters {
+			if now.Sub(e.seen) > idle {
+				delete(cl.limiters, k)
+			}
+		}
+		cl.lastSweep = now
+	}
+	e, ok := cl.limiters[key]
+	if !ok {
+		e = &clientEntry{limiter: newLimiter(&cl.cf.RateLimit)}
+		cl.limiters[key] = e
+	}
+	e.seen = now
+	return reserve(e.limiter, now)
+}
+
+// rateLimiter limits rate of incoming requests globally and for each client.
+type rateLimiter struct {
+	gl *rate.Limiter
+	cl *clientLimiter
+}
+
+// allow takes tokens for a request from both limiters. When a limit is
+// exceeded, it returns the duration to wait and detail of the limit. A token
+// of the client is given back when the global limit is exceeded, not to
+// penalize clients for global overload.
+func (rl *rateLimiter) allow(r *http.Request, now time.Time) (time.Duration, string) {
+	var cr *rate.Reservation
+	if rl.cl != nil {
+		x, d := rl.cl.reserve(r, now)
+		if d > 0 {
+			return d, "client rate limit exceeded"
+		}
+		cr = x
+	}
+	if rl.gl != nil {
+		if _, d := reserve(rl.gl, now); d > 0 {
+			if cr != nil {
+				cr.CancelAt(now)
+			}
+			return d, "rate limit exceeded"
+		}
+	}
+	return 0, ""
+}
+
+// rateLimitHandler wraps a handler with global and per client rate
+// limitations. Requests which exceed limits are rejected with "429 Too Many
+// Requests".
+func (b *Broker) rateLimitHandler(h http.Handler) http.Handler {
+	rl := &rateLimiter{
+		gl: newLimiter(b.cf.RateLimit),
+		cl: newClientLimiter(b.cf.ClientRateLimit),
+	}
+	if rl.gl == nil && rl.cl == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d, detail := rl.allow(r, time.Now()); d > 0 {
+			b.reportTooManyRequests(w, d, detail)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (b *Broker) reportTooManyRequests(w http.ResponseWriter, d time.Duration, detail string) {
+	atomic.AddInt64(&b.stat.RequestLimited, 1)
+	sec := int64(math.Ceil(d.Seconds()))
+	if sec < 1 {
+		sec = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(sec, 10))
+	b.reportError(w, "", http.StatusTooManyRequests, "too many requests",
+		fmt.Errorf("%s, retry after %d seconds", detail, sec))
+}
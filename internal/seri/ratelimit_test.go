@@ -0,0 +1,169 @@
+package seri
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRequest(remoteAddr, apiKey string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = remoteAddr
+	if apiKey != "" {
+		r.Header.Set("X-API-Key", apiKey)
+	}
+	return r
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	b, err := NewBroker(&Config{
+		Endpoints: map[string]Endpoint{"ep1": {URL: "http://127.0.0.1:1/"}},
+		RateLimit: &RateLimit{Rate: 0.1, Burst: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	h := b.rateLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newTestRequest("192.0.2.1:1234", ""))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request #%d is rejected: %d", i, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newTestRequest("192.0.2.2:1234", ""))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit is accepted: %d", w.Code)
+	}
+	// a token is given per 10 seconds.
+	if got := w.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("unexpected Retry-After: %q", got)
+	}
+	if got := b.stat.RequestLimited; got != 1 {
+		t.Errorf("unexpected number of limited requests: %d", got)
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		keyHeader string
+		same      [2]*http.Request
+		other     *http.Request
+	}{
+		{
+			name: "ip",
+			same: [2]*http.Request{
+				newTestRequest("192.0.2.1:1000", ""),
+				newTestRequest("192.0.2.1:2000", "key2"),
+			},
+			other: newTestRequest("192.0.2.2:1000", ""),
+		},
+		{
+			name:      "api key",
+			keyHeader: "X-API-Key",
+			same: [2]*http.Request{
+				newTestRequest("192.0.2.1:1000", "key1"),
+				newTestRequest("192.0.2.2:1000", "key1"),
+			},
+			other: newTestRequest("192.0.2.1:1000", "key2"),
+		},
+	} {
+		rl := &rateLimiter{cl: newClientLimiter(&ClientRateLimit{
+			RateLimit: RateLimit{Rate: 1, Burst: 1},
+			KeyHeader: tc.keyHeader,
+		})}
+		now := time.Now()
+		if d, _ := rl.allow(tc.same[0], now); d != 0 {
+			t.Fatalf("%s: first request is rejected: %s", tc.name, d)
+		}
+		if d, detail := rl.allow(tc.same[1], now); d != time.Second || detail != "client rate limit exceeded" {
+			t.Errorf("%s: request of same client is not limited: %s %q", tc.name, d, detail)
+		}
+		if d, _ := rl.allow(tc.other, now); d != 0 {
+			t.Errorf("%s: request of other client is limited: %s", tc.name, d)
+		}
+		if d, _ := rl.allow(tc.same[1], now.Add(time.Second)); d != 0 {
+			t.Errorf("%s: token is not refilled: %s", tc.name, d)
+		}
+	}
+}
+
+// TestGlobalLimitRefundsClientToken checks that requests rejected by the
+// global limit don't spend tokens of the client.
+func TestGlobalLimitRefundsClientToken(t *testing.T) {
+	rl := &rateLimiter{
+		gl: newLimiter(&RateLimit{Rate: 1, Burst: 1}),
+		cl: newClientLimiter(&ClientRateLimit{RateLimit: RateLimit{Rate: 0.1, Burst: 1}}),
+	}
+	a := newTestRequest("192.0.2.1:1000", "")
+	b := newTestRequest("192.0.2.2:1000", "")
+	now := time.Now()
+	if d, _ := rl.allow(a, now); d != 0 {
+		t.Fatalf("first request is rejected: %s", d)
+	}
+	if d, detail := rl.allow(b, now); d == 0 || detail != "rate limit exceeded" {
+		t.Fatalf("global limit is not applied: %s %q", d, detail)
+	}
+	// the client would wait 10 seconds, if the token was spent.
+	if d, detail := rl.allow(b, now.Add(time.Second)); d != 0 {
+		t.Errorf("token of the client is not given back: %s %q", d, detail)
+	}
+}
+
+func TestEndpointRateLimit(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	ep := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+	}))
+	defer ep.Close()
+	b, err := NewBroker(&Config{
+		Endpoints: map[string]Endpoint{"ep1": {
+			URL:       ep.URL,
+			RateLimit: &RateLimit{Rate: 20, Burst: 1},
+		}},
+		HTTPClientTimeout: Duration(5 * time.Second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	h := b.Handler()
+
+	const n = 5
+	for i := 0; i < n; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request #%d is rejected: %d", i, w.Code)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		mu.Lock()
+		l := len(times)
+		mu.Unlock()
+		if l == n {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(times) != n {
+		t.Fatalf("endpoint received %d requests, want %d", len(times), n)
+	}
+	// 20 requests per second: 4 intervals take 200ms at least.
+	if d := times[n-1].Sub(times[0]); d < 150*time.Millisecond {
+		t.Errorf("requests to the endpoint are not limited: %s", d)
+	}
+}
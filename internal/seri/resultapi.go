@@ -0,0 +1,180 @@
+package seri
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (ra *ResultAPI) path() string {
+	if ra.Path != "" {
+		return strings.TrimSuffix(ra.Path, "/")
+	}
+	return "/_results"
+}
+
+// resultAPIHandler wraps a handler to serve the result API on "path" of
+// "result_api", when it is configured.
+//
+//   - GET {path} lists recent requests, filtered by query parameters:
+//     "since", "until", "url", "method", "missing" and "limit".
+//   - GET {path}/{id} gets a response of a request.
+//   - DELETE {path}/{id} deletes a request, when "allow_delete" is enabled.
+func (b *Broker) resultAPIHandler(h http.Handler) http.Handler {
+	ra := b.cf.ResultAPI
+	if ra == nil {
+		return h
+	}
+	path := ra.path()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id string
+		switch {
+		case r.URL.Path == path:
+		case strings.HasPrefix(r.URL.Path, path+"/"):
+			id = r.URL.Path[len(path)+1:]
+		default:
+			h.ServeHTTP(w, r)
+			return
+		}
+		if id != "" && r.Method == http.MethodDelete && ra.AllowDelete {
+			b.serveDelete(w, r, id)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			allow := "GET, HEAD"
+			if id != "" && ra.AllowDelete {
+				allow += ", DELETE"
+			}
+			w.Header().Add("Allow", allow)
+			b.reportError(w, "", http.StatusMethodNotAllowed, "method not allowed",
+				fmt.Errorf("method %s is not allowed", r.Method))
+			return
+		}
+		if id == "" {
+			b.serveList(w, r)
+			return
+		}
+		b.serveResult(w, r, id)
+	})
+}
+
+func (b *Broker) serveResult(w http.ResponseWriter, r *http.Request, id string) {
+	ctx, cancel := b.storeContext(r.Context())
+	defer cancel()
+	resp, err := b.st.GetResponse(ctx, id)
+	if err == nil && (resp == nil || resp.ID == "") {
+		// some stores return an empty response for unknown requests.
+		err = fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		b.reportStoreError(w, id, "failed to get a response", err)
+		return
+	}
+	writeJSON(w, r, resp)
+}
+
+// serveDelete deletes a request, and responds "204 No Content".
+func (b *Broker) serveDelete(w http.ResponseWriter, r *http.Request, id string) {
+	d, ok := b.st.(Deleter)
+	if !ok {
+		b.reportStoreError(w, id, "failed to delete a request", ErrNotSupported)
+		return
+	}
+	ctx, cancel := b.storeContext(r.Context())
+	defer cancel()
+	if err := d.DeleteRequest(ctx, id); err != nil {
+		b.reportStoreError(w, id, "failed to delete a request", err)
+		return
+	}
+	b.log.Printf("[INFO] broker: request %s is deleted", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *Broker) serveList(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r.URL.Query(), time.Now())
+	if err != nil {
+		b.reportError(w, "", http.StatusBadRequest, "invalid query", err)
+		return
+	}
+	l, ok := b.st.(Lister)
+	if !ok {
+		b.reportStoreError(w, "", "failed to list requests", ErrNotSupported)
+		return
+	}
+	ctx, cancel := b.storeContext(r.Context())
+	defer cancel()
+	list, err := l.ListRequests(ctx, opts)
+	if err != nil {
+		b.reportStoreError(w, "", "failed to list requests", err)
+		return
+	}
+	if list == nil {
+		list = []*RequestInfo{}
+	}
+	writeJSON(w, r, list)
+}
+
+// reportStoreError reports an error of the store, with a status code for it.
+func (b *Broker) reportStoreError(w http.ResponseWriter, reqid, title string, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrNotSupported):
+		code = http.StatusNotImplemented
+	case b.isTimeout(err):
+		code = http.StatusGatewayTimeout
+	}
+	b.reportError(w, reqid, code, title, err)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// parseListOptions parses query parameters to ListOptions. "missing" is a
+// comma separated list of endpoint names.
+func parseListOptions(q map[string][]string, now time.Time) (*ListOptions, error) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	opts := &ListOptions{
+		URLPrefix: get("url"),
+		Method:    get("method"),
+	}
+	var err error
+	if s := get("since"); s != "" {
+		opts.Since, err = ParseTime(s, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid \"since\": %w", err)
+		}
+	}
+	if s := get("until"); s != "" {
+		opts.Until, err = ParseTime(s, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid \"until\": %w", err)
+		}
+	}
+	if s := get("missing"); s != "" {
+		opts.Missing = strings.Split(s, ",")
+	}
+	if s := get("limit"); s != "" {
+		opts.Limit, err = strconv.Atoi(s)
+		if err != nil || opts.Limit < 0 {
+			return nil, fmt.Errorf("invalid \"limit\": %q", s)
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,231 @@
+package seri_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+// request sends a request, and returns its status code, header and body.
+func request(t *testing.T, method, url string) (int, http.Header, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header, b
+}
+
+// newResultAPIBroker creates a broker with the result API, and endpoints "a"
+// which responds and "b" which never responds.
+func newResultAPIBroker(t *testing.T, ra *seri.ResultAPI) (seri.Storage, *httptest.Server) {
+	t.Helper()
+	_, st, ts := newTestBroker(t, &seri.Config{
+		Endpoints: map[string]seri.Endpoint{
+			"a": {URL: textEndpoint(t, "hello", 0)},
+			"b": {URL: blockingEndpoint(t)},
+		},
+		ResultAPI: ra,
+	})
+	return st, ts
+}
+
+// storeOnly hides optional capabilities of a store, ex. Lister and Deleter.
+type storeOnly struct {
+	seri.Storage
+}
+
+// newStoreOnlyBroker creates a broker with the result API, and a store which
+// has no optional capabilities.
+func newStoreOnlyBroker(t *testing.T) *httptest.Server {
+	t.Helper()
+	cf := &seri.Config{
+		Endpoints: map[string]seri.Endpoint{"a": {URL: textEndpoint(t, "hello", 0)}},
+		ResultAPI: &seri.ResultAPI{AllowDelete: true},
+		StoreType: "gocache",
+		GoCache:   &seri.GoCache{ExpireIn: seri.Duration(time.Minute)},
+	}
+	st, err := seri.NewStorage(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := seri.NewBroker(cf, seri.WithStorage(storeOnly{st}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	ts := httptest.NewServer(b.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func listIDs(t *testing.T, url string) string {
+	t.Helper()
+	code, _, body := request(t, "GET", url)
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status: %d %s", code, body)
+	}
+	var list []*seri.RequestInfo
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatal(err)
+	}
+	if list == nil {
+		t.Fatalf("list is not an array: %s", body)
+	}
+	ids := make([]string, len(list))
+	for i, ri := range list {
+		ids[i] = ri.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestResultAPIList(t *testing.T) {
+	st, ts := newResultAPIBroker(t, &seri.ResultAPI{})
+	_, id1 := send(t, "GET", ts.URL+"/foo", "")
+	waitResponse(t, st, id1, hasResults(1))
+	_, id2 := send(t, "POST", ts.URL+"/bar", "hello")
+	waitResponse(t, st, id2, hasResults(1))
+
+	code, _, body := request(t, "GET", ts.URL+"/_results")
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status: %d %s", code, body)
+	}
+	var list []*seri.RequestInfo
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != id2 || list[1].ID != id1 {
+		t.Fatalf("requests are not listed from newest: %s", body)
+	}
+	if ri := list[1]; ri.Method != "GET" || ri.URL != "/foo" || strings.Join(ri.Results, ",") != "a" {
+		t.Errorf("unexpected request: %+v", ri)
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"limit=1", id2},
+		{"method=post", id2},
+		{"url=/foo", id1},
+		{"missing=b", id2 + "," + id1},
+		{"missing=a", ""},
+		{"since=1h", id2 + "," + id1},
+		{"until=1h", ""},
+	} {
+		if got := listIDs(t, ts.URL+"/_results?"+tc.query); got != tc.want {
+			t.Errorf("%q: unexpected list: want=%s got=%s", tc.query, tc.want, got)
+		}
+	}
+
+	if code, _, body := request(t, "GET", ts.URL+"/_results?limit=x"); code != http.StatusBadRequest {
+		t.Errorf("invalid query is accepted: %d %s", code, body)
+	}
+}
+
+func TestResultAPIGet(t *testing.T) {
+	st, ts := newResultAPIBroker(t, &seri.ResultAPI{Path: "/api/results/"})
+	_, id := send(t, "GET", ts.URL+"/foo", "")
+	waitResponse(t, st, id, hasResults(1))
+
+	code, h, body := request(t, "GET", ts.URL+"/api/results/"+id)
+	if code != http.StatusOK || !strings.HasPrefix(h.Get("Content-Type"), "application/json") {
+		t.Fatalf("unexpected response: %d %s %s", code, h.Get("Content-Type"), body)
+	}
+	var r seri.Response
+	if err := json.Unmarshal(body, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != id || r.URL != "/foo" || r.Results["a"] == nil || string(r.Results["a"].Data) != "hello" {
+		t.Errorf("unexpected response: %s", body)
+	}
+	if code, _, body := request(t, "HEAD", ts.URL+"/api/results/"+id); code != http.StatusOK || len(body) != 0 {
+		t.Errorf("unexpected response of HEAD: %d %q", code, body)
+	}
+
+	code, _, body = request(t, "GET", ts.URL+"/api/results/unknown")
+	var pd struct {
+		Status    int    `json:"status"`
+		RequestID string `json:"request_id"`
+	}
+	json.Unmarshal(body, &pd)
+	if code != http.StatusNotFound || pd.Status != http.StatusNotFound || pd.RequestID != "unknown" {
+		t.Errorf("unexpected response for an unknown request: %d %s", code, body)
+	}
+
+	// other paths are proxied to endpoints.
+	if code, _ := send(t, "GET", ts.URL+"/api/resultsfoo", ""); code != http.StatusOK {
+		t.Errorf("unexpected status: %d", code)
+	}
+}
+
+func TestResultAPINotSupported(t *testing.T) {
+	ts := newStoreOnlyBroker(t)
+	if code, _, body := request(t, "GET", ts.URL+"/_results"); code != http.StatusNotImplemented {
+		t.Errorf("unexpected response of listing: %d %s", code, body)
+	}
+	if code, _, body := request(t, "DELETE", ts.URL+"/_results/r1"); code != http.StatusNotImplemented {
+		t.Errorf("unexpected response of deletion: %d %s", code, body)
+	}
+}
+
+func TestResultAPIDelete(t *testing.T) {
+	st, ts := newResultAPIBroker(t, &seri.ResultAPI{AllowDelete: true})
+	_, id := send(t, "GET", ts.URL+"/foo", "")
+	waitResponse(t, st, id, hasResults(1))
+
+	if code, _, body := request(t, "DELETE", ts.URL+"/_results/"+id); code != http.StatusNoContent {
+		t.Fatalf("unexpected response of DELETE: %d %s", code, body)
+	}
+	if code, _, body := request(t, "GET", ts.URL+"/_results/"+id); code != http.StatusNotFound {
+		t.Errorf("deleted request is found: %d %s", code, body)
+	}
+	if code, _, body := request(t, "DELETE", ts.URL+"/_results/"+id); code != http.StatusNotFound {
+		t.Errorf("unexpected response of DELETE for a deleted request: %d %s", code, body)
+	}
+
+	// other methods than GET, HEAD and DELETE are not allowed.
+	for _, tc := range []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{"POST", "/_results/" + id, "GET, HEAD, DELETE"},
+		{"POST", "/_results", "GET, HEAD"},
+		{"DELETE", "/_results", "GET, HEAD"},
+	} {
+		code, h, body := request(t, tc.method, ts.URL+tc.path)
+		if code != http.StatusMethodNotAllowed || h.Get("Allow") != tc.allow {
+			t.Errorf("%s %s: unexpected response: %d Allow=%q %s", tc.method, tc.path, code, h.Get("Allow"), body)
+		}
+	}
+}
+
+func TestResultAPIDeleteNotAllowed(t *testing.T) {
+	st, ts := newResultAPIBroker(t, &seri.ResultAPI{})
+	_, id := send(t, "GET", ts.URL+"/foo", "")
+	waitResponse(t, st, id, hasResults(1))
+
+	code, h, body := request(t, "DELETE", ts.URL+"/_results/"+id)
+	if code != http.StatusMethodNotAllowed || h.Get("Allow") != "GET, HEAD" {
+		t.Errorf("unexpected response of DELETE: %d Allow=%q %s", code, h.Get("Allow"), body)
+	}
+	if code, _, body := request(t, "GET", ts.URL+"/_results/"+id); code != http.StatusOK {
+		t.Errorf("request is deleted: %d %s", code, body)
+	}
+}
@@ -1,7 +1,6 @@
 package seri
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -12,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,15 +19,23 @@ import (
 	"github.com/google/uuid"
 	"github.com/koron-go/ctxsrv"
 	"github.com/koron-go/reqlim"
+	"golang.org/x/time/rate"
 )
 
 // Stat stores statistics for requests.
 type Stat struct {
-	Inquire        int64
-	InquireFail    int64
-	InquireTimeout int64
-	StoreTimeout   int64
-	WorkerFail     int64
+	Inquire          int64
+	InquireFail      int64
+	InquireTimeout   int64
+	StoreTimeout     int64
+	WorkerFail       int64
+	RequestLimited   int64
+	InquireLimited   int64
+	InquireAborted   int64
+	InquireTruncated int64
+	StoreBuffered    int64
+	StoreReplayed    int64
+	StoreDropped     int64
 }
 
 // Broker traps and dispatch HTTP requests to servers.
@@ -45,17 +53,32 @@ type Broker struct {
 	stat Stat
 
 	worker *Worker
+
+	// ctx is base context for inquiries, canceled to abort them.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu guards closing and beginning of wg.
+	mu      sync.RWMutex
+	closing bool
+	wg      sync.WaitGroup
+
+	health health
 }
 
 type endpoint struct {
 	name string
 	url  *url.URL
 	to   time.Duration
+	lim  *rate.Limiter
 }
 
 func conf2eps(cf *Config) ([]endpoint, error) {
 	eps := make([]endpoint, 0, len(cf.Endpoints))
 	for n, ep := range cf.Endpoints {
+		if IsReservedName(n) {
+			return nil, fmt.Errorf("reserved name can't be used for an endpoint: %q", n)
+		}
 		u, err := url.Parse(ep.URL)
 		if err != nil {
 			return nil, err
@@ -68,6 +91,7 @@ func conf2eps(cf *Config) ([]endpoint, error) {
 			name: n,
 			url:  u,
 			to:   time.Duration(to),
+			lim:  newLimiter(ep.RateLimit),
 		})
 	}
 	return eps, nil
@@ -81,8 +105,23 @@ func eps2ens(eps []endpoint) []string {
 	return ens
 }
 
+// Option is an option of NewBroker.
+type Option func(*options)
+
+type options struct {
+	st Storage
+}
+
+// WithStorage makes a broker use st instead of a storage of "store_type".
+// The broker closes st at Close.
+func WithStorage(st Storage) Option {
+	return func(o *options) {
+		o.st = st
+	}
+}
+
 // NewBroker creates a new `Broker`
-func NewBroker(cf *Config) (*Broker, error) {
+func NewBroker(cf *Config, opts ...Option) (*Broker, error) {
 	if len(cf.Endpoints) == 0 {
 		return nil, errors.New("no endpoints")
 	}
@@ -92,18 +131,53 @@ func NewBroker(cf *Config) (*Broker, error) {
 	}
 	ens := eps2ens(eps)
 
-	st, err := newStorage(cf, ens)
-	if err != nil {
-		return nil, err
+	if cc := cf.Compression; cc != nil && cc.Algorithm != "" {
+		if _, err := compress(cc.Algorithm, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if hc := cf.HealthCheck; hc != nil {
+		switch hc.OnStartupFailure {
+		case "", "fail", "degrade":
+		default:
+			return nil, fmt.Errorf("unsupported \"on_startup_failure\": %q", hc.OnStartupFailure)
+		}
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	st := o.st
+	if st == nil {
+		st, err = newStorage(cf, ens)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cf.Batch != nil {
+		st = newBatchStorage(st, cf.Batch)
+	}
+	if cf.Degrade != nil {
+		ds, err := newDegradeStorage(st, cf.Degrade, time.Duration(cf.StoreTimeout))
+		if err != nil {
+			if c, ok := st.(io.Closer); ok {
+				c.Close()
+			}
+			return nil, err
+		}
+		st = ds
 	}
 
 	var w *Worker
 	if cf.WorkerNum > 0 {
 		log.Printf("[DEBUG] %d workers launched", cf.WorkerNum)
-		w := NewWorker(cf.WorkerNum)
+		w = NewWorker(cf.WorkerNum)
 		w.Start()
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	b := &Broker{
 		cf:     cf.Clone(),
 		log:    log.New(os.Stderr, "", log.LstdFlags),
@@ -112,35 +186,122 @@ func NewBroker(cf *Config) (*Broker, error) {
 		eps:    eps,
 		ens:    ens,
 		worker: w,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	if hc := cf.HealthCheck; hc != nil {
+		err := b.checkHealth(ctx)
+		if err != nil && hc.OnStartupFailure != "degrade" {
+			b.Close()
+			return nil, fmt.Errorf("store is unhealthy: %w", err)
+		}
+		b.startHealthCheck()
 	}
 	return b, nil
 }
 
-// Close closes broker.
+// Close closes broker. Inquiries in flight are aborted.
 func (b *Broker) Close() {
+	b.stopAccepting()
+	b.cancel()
+	b.wg.Wait()
+	b.health.wg.Wait()
 	if b.worker != nil {
 		b.worker.Close()
 	}
+	if c, ok := b.st.(io.Closer); ok {
+		c.Close()
+	}
 }
 
-// Serve starts HTTP service.
+// Shutdown stops accepting new requests, and waits for all accepted
+// inquiries to be stored until ctx is done. Then it aborts unfinished
+// inquiries, records them as "aborted", and closes broker.
+func (b *Broker) Shutdown(ctx context.Context) error {
+	b.stopAccepting()
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		b.log.Printf("[WARN] broker: abort unfinished inquiries: %s", err)
+	}
+	b.Close()
+	return err
+}
+
+func (b *Broker) stopAccepting() {
+	b.mu.Lock()
+	b.closing = true
+	b.mu.Unlock()
+}
+
+// accept reserves n inquiries to be waited by Shutdown. It returns false
+// when the broker doesn't accept inquiries anymore.
+func (b *Broker) accept(n int) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closing {
+		return false
+	}
+	b.wg.Add(n)
+	return true
+}
+
+// Serve starts HTTP service. When ctx is canceled, it shuts down the HTTP
+// server and drains accepted inquiries within "shutdown_timeout" in total.
 func (b *Broker) Serve(ctx context.Context) error {
 	b.log.Printf("[INFO] broker: listening on %s", b.cf.Addr)
-	var h http.Handler = http.HandlerFunc(b.serveHTTP)
-	if limit := b.cf.MaxHandlers; limit > 0 {
-		b.log.Printf("[DEBUG] max handlers limitation: %d", b.cf.MaxHandlers)
-		h = reqlim.Handler(h, limit, "")
-	}
-	cfg := ctxsrv.HTTP(&http.Server{Addr: b.cf.Addr, Handler: h}).
-		WithShutdownTimeout(time.Duration(b.cf.ShutdownTimeout)).
+	timeout := time.Duration(b.cf.ShutdownTimeout)
+	// deadline is determined when ctx is canceled, to share the timeout
+	// between the HTTP server and accepted inquiries.
+	var deadline time.Time
+	cfg := ctxsrv.HTTP(&http.Server{Addr: b.cf.Addr, Handler: b.Handler()}).
+		WithShutdownTimeout(timeout).
 		WithDoneContext(func() {
 			b.log.Printf("[INFO] broker: context canceled")
-			b.Close()
+			if timeout > 0 {
+				deadline = time.Now().Add(timeout)
+			}
+			b.stopAccepting()
 		}).
 		WithDoneServer(func() {
 			b.log.Printf("[INFO] broker: closed")
 		})
-	return cfg.ServeWithContext(ctx)
+	err := cfg.ServeWithContext(ctx)
+
+	// drain accepted inquiries until the deadline.
+	if deadline.IsZero() && timeout > 0 {
+		// the server stopped by itself.
+		deadline = time.Now().Add(timeout)
+	}
+	sctx := context.Background()
+	if !deadline.IsZero() {
+		x, cancel := context.WithDeadline(sctx, deadline)
+		defer cancel()
+		sctx = x
+	}
+	b.Shutdown(sctx)
+	b.log.Printf("[INFO] broker: drained")
+	return err
+}
+
+// Handler returns a handler which serves requests as Serve does, to serve
+// them with other servers, e.g. httptest.Server.
+func (b *Broker) Handler() http.Handler {
+	var h http.Handler = http.HandlerFunc(b.serveHTTP)
+	if limit := b.cf.MaxHandlers; limit > 0 {
+		b.log.Printf("[DEBUG] max handlers limitation: %d", b.cf.MaxHandlers)
+		h = reqlim.Handler(h, limit, "")
+	}
+	h = b.rateLimitHandler(h)
+	h = b.readyHandler(h)
+	return b.resultAPIHandler(h)
 }
 
 func (b *Broker) reportError(w http.ResponseWriter, reqid string, code int, title string, err error) {
@@ -161,17 +322,23 @@ func (b *Broker) serveHTTP(w http.ResponseWriter, r *http.Request) {
 	case "GET":
 		b.dispatch(w, r, func(reqid string, ep *endpoint, qs string) {
 			b.inquire(reqid, ep, "GET", qs, "", nil)
-		})
+		}, nil)
 
 	case "POST":
-		d, err := ioutil.ReadAll(r.Body)
+		p, err := b.readPayload(w, r)
 		if err != nil {
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				b.reportError(w, "(N/A)", http.StatusRequestEntityTooLarge, "request entity too large", err)
+				return
+			}
 			b.reportError(w, "(N/A)", 500, "failed to read body", err)
+			return
 		}
 		ct := r.Header.Get("Content-Type")
 		b.dispatch(w, r, func(reqid string, ep *endpoint, qs string) {
-			b.inquire(reqid, ep, "POST", qs, ct, bytes.NewReader(d))
-		})
+			b.inquire(reqid, ep, "POST", qs, ct, p.reader())
+		}, p.close)
 
 	default:
 		w.Header().Add("Allow", allowMethods)
@@ -201,17 +368,45 @@ type problemDetail struct {
 type response struct {
 	RequestID string   `json:"request_id"`
 	Endpoints []string `json:"endpoints"`
+
+	// Degraded is true when the request is buffered by degraded mode.
+	Degraded bool `json:"degraded,omitempty"`
 }
 
-func (b *Broker) dispatch(w http.ResponseWriter, r *http.Request, goFn func(reqid string, ep *endpoint, qs string)) {
+// dispatch stores a request and starts inquiries to all endpoints with goFn.
+// done is called when all inquiries are finished, if not nil.
+func (b *Broker) dispatch(w http.ResponseWriter, r *http.Request, goFn func(reqid string, ep *endpoint, qs string), done func()) {
+	if done == nil {
+		done = func() {}
+	}
 	reqid, err := b.newReqid()
 	if err != nil {
+		done()
 		b.reportError(w, "(N/A)", 500, "failed to genrate ID", err)
 		return
 	}
 
-	err = b.st.StoreRequest(reqid, r.Method, r.URL.String())
+	if !b.accept(len(b.eps)) {
+		done()
+		b.reportError(w, "", http.StatusServiceUnavailable, "shutting down",
+			errors.New("broker is shutting down"))
+		return
+	}
+	remain := int32(len(b.eps))
+	finish := func() {
+		if atomic.AddInt32(&remain, -1) == 0 {
+			b.complete(reqid)
+			done()
+		}
+		b.wg.Done()
+	}
+
+	sctx, cancel := b.storeContext(r.Context())
+	defer cancel()
+	degraded, err := b.storeRequest(sctx, reqid, r.Method, r.URL.String())
 	if err != nil {
+		b.wg.Add(-len(b.eps))
+		done()
 		b.reportError(w, reqid, 500, "failed to prepare storage", err)
 		return
 	}
@@ -220,8 +415,12 @@ func (b *Broker) dispatch(w http.ResponseWriter, r *http.Request, goFn func(reqi
 	if b.worker != nil {
 		for i := range b.eps {
 			p := &b.eps[i]
-			err := b.worker.Run(func() { goFn(reqid, p, qs) })
+			err := b.worker.Run(func() {
+				defer finish()
+				goFn(reqid, p, qs)
+			})
 			if err != nil {
+				finish()
 				atomic.AddInt64(&b.stat.WorkerFail, 1)
 				b.log.Printf("[WARN] worker: reqid=%s epname=%s: failed to queue: %s", reqid, p.name, err)
 			}
@@ -229,7 +428,10 @@ func (b *Broker) dispatch(w http.ResponseWriter, r *http.Request, goFn func(reqi
 	} else {
 		go func() {
 			for i := range b.eps {
-				go goFn(reqid, &b.eps[i], qs)
+				go func(p *endpoint) {
+					defer finish()
+					goFn(reqid, p, qs)
+				}(&b.eps[i])
 			}
 		}()
 	}
@@ -239,6 +441,7 @@ func (b *Broker) dispatch(w http.ResponseWriter, r *http.Request, goFn func(reqi
 	json.NewEncoder(w).Encode(&response{
 		RequestID: reqid,
 		Endpoints: b.ens,
+		Degraded:  degraded,
 	})
 }
 
@@ -268,26 +471,93 @@ func (b *Broker) isTimeout(err error) bool {
 	return false
 }
 
+// storeContext creates a context to access the storage, with "store_timeout".
+func (b *Broker) storeContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if d := time.Duration(b.cf.StoreTimeout); d > 0 {
+		return context.WithTimeout(parent, d)
+	}
+	return context.WithCancel(parent)
+}
+
+// complete notifies the storage that all inquiries for a request are
+// finished.
+func (b *Broker) complete(reqid string) {
+	c, ok := b.st.(Completer)
+	if !ok {
+		return
+	}
+	// the base context may be canceled already.
+	ctx, cancel := b.storeContext(context.Background())
+	defer cancel()
+	err := c.CompleteRequest(ctx, reqid)
+	if err != nil {
+		b.log.Printf("[WARN] broker: reqid=%s: failed to complete: %s", reqid, err)
+	}
+}
+
+// aborted checks whether inquiries are aborted by shutdown of the broker, and
+// records the inquiry as "aborted" when so.
+func (b *Broker) aborted(reqid string, ep *endpoint) bool {
+	if b.ctx.Err() == nil {
+		return false
+	}
+	atomic.AddInt64(&b.stat.InquireAborted, 1)
+	if m, ok := b.st.(Marker); ok {
+		// the base context is canceled already.
+		ctx, cancel := b.storeContext(context.Background())
+		defer cancel()
+		err := m.MarkResponse(ctx, reqid, ep.name, MarkAborted)
+		if err != nil {
+			b.log.Printf("[WARN] worker: reqid=%s epname=%s: failed to mark as aborted: %s", reqid, ep.name, err)
+		}
+	}
+	return true
+}
+
 func (b *Broker) inquire(reqid string, ep *endpoint, method, qs, ct string, body io.Reader) {
 	atomic.AddInt64(&b.stat.Inquire, 1)
-	ctx := context.Background()
+	ctx := b.ctx
 	if ep.to > 0 {
 		x, cancel := context.WithTimeout(ctx, ep.to)
 		defer cancel()
 		ctx = x
 	}
+	if ep.lim != nil {
+		err := ep.lim.Wait(ctx)
+		if err != nil {
+			if b.aborted(reqid, ep) {
+				return
+			}
+			atomic.AddInt64(&b.stat.InquireLimited, 1)
+			return
+		}
+	}
 	u := b.concatQuery(ep.url, qs).String()
 	req, err := http.NewRequestWithContext(ctx, method, u, body)
-	if ct != "" {
-		req.Header.Set("Content-Type", ct)
-	}
 	if err != nil {
 		atomic.AddInt64(&b.stat.InquireFail, 1)
 		b.log.Printf("[WARN] worker: reqid=%s epname=%s: failed to request: %s", reqid, ep.name, err)
 		return
 	}
+	if ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	if sr, ok := body.(*io.SectionReader); ok {
+		req.ContentLength = sr.Size()
+		// a spooled body is sent again on redirects.
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(sr, 0, sr.Size())), nil
+		}
+	}
+	if cc := b.cf.Compression; cc != nil && len(cc.AcceptEncoding) > 0 {
+		req.Header.Set("Accept-Encoding", strings.Join(cc.AcceptEncoding, ", "))
+	}
+	start := time.Now()
 	resp, err := b.cl.Do(req)
 	if err != nil {
+		if b.aborted(reqid, ep) {
+			return
+		}
 		if b.isTimeout(err) {
 			atomic.AddInt64(&b.stat.InquireTimeout, 1)
 			return
@@ -297,15 +567,42 @@ func (b *Broker) inquire(reqid string, ep *endpoint, method, qs, ct string, body
 		return
 	}
 	defer resp.Body.Close()
-	//sc := resp.StatusCode
-	da, err := ioutil.ReadAll(resp.Body)
+	var rd io.Reader = resp.Body
+	max := b.cf.MaxResponseSize
+	if max > 0 {
+		rd = io.LimitReader(rd, max+1)
+	}
+	da, err := ioutil.ReadAll(rd)
 	if err != nil {
+		if b.aborted(reqid, ep) {
+			return
+		}
 		atomic.AddInt64(&b.stat.InquireFail, 1)
 		b.log.Printf("[WARN] worker: reqid=%s epname=%s: failed to read: %s", reqid, ep.name, err)
 		return
 	}
-	err = b.st.StoreResponse(reqid, ep.name, da)
+	info := ResultInfo{
+		Status:      resp.StatusCode,
+		Latency:     time.Since(start),
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	truncated := max > 0 && int64(len(da)) > max
+	if truncated {
+		da = da[:max]
+	}
+	da, truncated, err = b.encodeResult(resp.Header.Get("Content-Encoding"), da, truncated)
+	if err != nil {
+		atomic.AddInt64(&b.stat.InquireFail, 1)
+		b.log.Printf("[WARN] worker: reqid=%s epname=%s: failed to encode: %s", reqid, ep.name, err)
+		return
+	}
+	sctx, cancel := b.storeContext(b.ctx)
+	defer cancel()
+	err = storeResult(sctx, b.st, reqid, ep.name, da, info)
 	if err != nil {
+		if b.aborted(reqid, ep) {
+			return
+		}
 		if b.isTimeout(err) {
 			atomic.AddInt64(&b.stat.StoreTimeout, 1)
 			return
@@ -314,15 +611,34 @@ func (b *Broker) inquire(reqid string, ep *endpoint, method, qs, ct string, body
 		b.log.Printf("[WARN] worker: reqid=%s epname=%s: failed to store: %s", reqid, ep.name, err)
 		return
 	}
+	if truncated {
+		atomic.AddInt64(&b.stat.InquireTruncated, 1)
+		if m, ok := b.st.(Marker); ok {
+			err := m.MarkResponse(sctx, reqid, ep.name, MarkTruncated)
+			if err != nil {
+				b.log.Printf("[WARN] worker: reqid=%s epname=%s: failed to mark as truncated: %s", reqid, ep.name, err)
+			}
+		}
+	}
 }
 
 // Stat gets current Stat, then resets it.
 func (b *Broker) Stat() Stat {
-	return Stat{
-		Inquire:        atomic.SwapInt64(&b.stat.Inquire, 0),
-		InquireFail:    atomic.SwapInt64(&b.stat.InquireFail, 0),
-		InquireTimeout: atomic.SwapInt64(&b.stat.InquireTimeout, 0),
-		StoreTimeout:   atomic.SwapInt64(&b.stat.StoreTimeout, 0),
-		WorkerFail:     atomic.SwapInt64(&b.stat.WorkerFail, 0),
+	st := Stat{
+		Inquire:          atomic.SwapInt64(&b.stat.Inquire, 0),
+		InquireFail:      atomic.SwapInt64(&b.stat.InquireFail, 0),
+		InquireTimeout:   atomic.SwapInt64(&b.stat.InquireTimeout, 0),
+		StoreTimeout:     atomic.SwapInt64(&b.stat.StoreTimeout, 0),
+		WorkerFail:       atomic.SwapInt64(&b.stat.WorkerFail, 0),
+		RequestLimited:   atomic.SwapInt64(&b.stat.RequestLimited, 0),
+		InquireLimited:   atomic.SwapInt64(&b.stat.InquireLimited, 0),
+		InquireAborted:   atomic.SwapInt64(&b.stat.InquireAborted, 0),
+		InquireTruncated: atomic.SwapInt64(&b.stat.InquireTruncated, 0),
+	}
+	if ds, ok := b.st.(*degradeStorage); ok {
+		st.StoreBuffered = atomic.SwapInt64(&ds.buffered, 0)
+		st.StoreReplayed = atomic.SwapInt64(&ds.replayed, 0)
+		st.StoreDropped = atomic.SwapInt64(&ds.dropped, 0)
 	}
+	return st
 }
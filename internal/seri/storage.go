@@ -1,25 +1,153 @@
 package seri
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"time"
+	"unicode/utf8"
 )
 
 // Response provides response's information which include request information
 // and responses from each end points.
 type Response struct {
-	ID      string            `json:"_id"`
-	Method  string            `json:"_method"`
-	URL     string            `json:"_url"`
-	Results map[string]string `json:"results,omitempty"`
+	ID      string             `json:"_id"`
+	Method  string             `json:"_method"`
+	URL     string             `json:"_url"`
+	Results map[string]*Result `json:"results,omitempty"`
+
+	// Marks provides marks for endpoints, which show why results are not
+	// available or not complete. Key is name of endpoint.
+	Marks map[string]string `json:"marks,omitempty"`
+}
+
+// Result is a result from an endpoint.
+type Result struct {
+	Data []byte
+
+	// ContentType is "Content-Type" header of the response. It is empty when
+	// the store doesn't keep it.
+	ContentType string
+}
+
+// resultJSON is JSON form of Result. Data is a string when it is valid UTF-8
+// text, otherwise it is encoded with base64.
+type resultJSON struct {
+	Data        string `json:"data"`
+	Encoding    string `json:"encoding"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+const (
+	encodingText   = "text"
+	encodingBase64 = "base64"
+)
+
+// MarshalJSON marshals a result without loss of binary data.
+func (r *Result) MarshalJSON() ([]byte, error) {
+	rj := resultJSON{ContentType: r.ContentType}
+	if utf8.Valid(r.Data) {
+		rj.Data = string(r.Data)
+		rj.Encoding = encodingText
+	} else {
+		rj.Data = base64.StdEncoding.EncodeToString(r.Data)
+		rj.Encoding = encodingBase64
+	}
+	return json.Marshal(&rj)
+}
+
+// UnmarshalJSON unmarshals a result which is marshaled by MarshalJSON.
+func (r *Result) UnmarshalJSON(b []byte) error {
+	var rj resultJSON
+	if err := json.Unmarshal(b, &rj); err != nil {
+		return err
+	}
+	switch rj.Encoding {
+	case encodingText:
+		r.Data = []byte(rj.Data)
+	case encodingBase64:
+		d, err := base64.StdEncoding.DecodeString(rj.Data)
+		if err != nil {
+			return err
+		}
+		r.Data = d
+	default:
+		return fmt.Errorf("unsupported encoding of result: %q", rj.Encoding)
+	}
+	r.ContentType = rj.ContentType
+	return nil
 }
 
 // Storage is requirements to store results.
+// Implementations should give up operations when the context is done.
 type Storage interface {
-	StoreRequest(reqid, method, url string) error
+	StoreRequest(ctx context.Context, reqid, method, url string) error
 
-	StoreResponse(reqid, name string, data []byte) error
+	StoreResponse(ctx context.Context, reqid, name string, data []byte) error
 
-	GetResponse(reqid string) (*Response, error)
+	GetResponse(ctx context.Context, reqid string) (*Response, error)
+}
+
+// MarkAborted is a mark for an endpoint, which shows the inquiry for it was
+// aborted by shutdown of the broker.
+const MarkAborted = "aborted"
+
+// MarkTruncated is a mark for an endpoint, which shows the result was
+// truncated by "max_response_size".
+const MarkTruncated = "truncated"
+
+// Marker is an optional capability of Storage to record marks for endpoints.
+type Marker interface {
+	MarkResponse(ctx context.Context, reqid, name, mark string) error
+}
+
+// Completer is an optional capability of Storage, to be notified that all
+// inquiries for a request are finished.
+type Completer interface {
+	CompleteRequest(ctx context.Context, reqid string) error
+}
+
+// Pinger is an optional capability of Storage, to check health of the
+// store.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Deleter is an optional capability of Storage, to delete a request before
+// expiration.
+type Deleter interface {
+	// DeleteRequest deletes a request with its results and marks. It returns
+	// ErrNotFound when nothing is deleted.
+	DeleteRequest(ctx context.Context, reqid string) error
+}
+
+// ResultInfo is additional information of a result from an endpoint.
+type ResultInfo struct {
+	// Status is HTTP status code of the response.
+	Status int
+
+	// Latency is duration from sending a request to receiving whole of the
+	// response.
+	Latency time.Duration
+
+	// ContentType is "Content-Type" header of the response.
+	ContentType string
+}
+
+// ResultStorer is an optional capability of Storage, to store a result with
+// additional information.
+type ResultStorer interface {
+	StoreResult(ctx context.Context, reqid, name string, data []byte, info ResultInfo) error
+}
+
+// storeResult stores a result with ResultStorer if available, otherwise with
+// StoreResponse.
+func storeResult(ctx context.Context, st Storage, reqid, name string, data []byte, info ResultInfo) error {
+	if rs, ok := st.(ResultStorer); ok {
+		return rs.StoreResult(ctx, reqid, name, data, info)
+	}
+	return st.StoreResponse(ctx, reqid, name, data)
 }
 
 // StorageFactoryFunc is function to create storage implementation.
@@ -34,19 +162,29 @@ func RegisterStorage(name string, fn StorageFactoryFunc) {
 
 type discardStore struct{}
 
-func (*discardStore) StoreRequest(reqid, method, url string) error {
-	return nil
+func (*discardStore) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	return ctx.Err()
 }
 
-func (*discardStore) StoreResponse(reqid, name string, data []byte) error {
-	return nil
+func (*discardStore) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return ctx.Err()
 }
 
-func (*discardStore) GetResponse(reqid string) (*Response, error) {
+func (*discardStore) MarkResponse(ctx context.Context, reqid, name, mark string) error {
+	return ctx.Err()
+}
+
+func (*discardStore) GetResponse(ctx context.Context, reqid string) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return &Response{ID: reqid}, nil
 }
 
-var _ Storage = (*discardStore)(nil)
+var (
+	_ Storage = (*discardStore)(nil)
+	_ Marker  = (*discardStore)(nil)
+)
 
 func newStorage(cf *Config, ens []string) (Storage, error) {
 	if fa, ok := storages[cf.StoreType]; ok {
@@ -0,0 +1,49 @@
+package seri
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestResultJSON(t *testing.T) {
+	for _, tc := range []struct {
+		data     []byte
+		encoding string
+	}{
+		{[]byte("hello"), "text"},
+		{[]byte{}, "text"},
+		// invalid UTF-8, which is broken by conversion to string.
+		{[]byte{0x89, 'P', 'N', 'G', 0x00, 0xff, 0xfe}, "base64"},
+		{[]byte("caf\xc3"), "base64"},
+	} {
+		b, err := json.Marshal(&Result{Data: tc.data, ContentType: "image/png"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rj resultJSON
+		if err := json.Unmarshal(b, &rj); err != nil {
+			t.Fatal(err)
+		}
+		if rj.Encoding != tc.encoding {
+			t.Errorf("%q: unexpected encoding: want=%s got=%s", tc.data, tc.encoding, rj.Encoding)
+		}
+		var r Result
+		if err := json.Unmarshal(b, &r); err != nil {
+			t.Fatalf("%q: %s", tc.data, err)
+		}
+		if !bytes.Equal(r.Data, tc.data) || r.ContentType != "image/png" {
+			t.Errorf("%q: not round-tripped: %q %q", tc.data, r.Data, r.ContentType)
+		}
+	}
+
+	for _, s := range []string{
+		`{"data":"hello","encoding":"gzip"}`,
+		`{"data":"!!!","encoding":"base64"}`,
+	} {
+		var r Result
+		if err := json.Unmarshal([]byte(s), &r); err == nil {
+			t.Errorf("%s: should fail", s)
+		}
+	}
+}
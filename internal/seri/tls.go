@@ -0,0 +1,57 @@
+package seri
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLS provides configuration of TLS connections to stores.
+type TLS struct {
+	// CAFile is path of PEM file for CA certificates to verify servers.
+	// Default empty means system's CA certificates.
+	CAFile string `json:"ca_file,omitempty"`
+
+	// CertFile and KeyFile are paths of PEM files for client certificate.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+
+	// ServerName is used to verify the hostname of servers.
+	ServerName string `json:"server_name,omitempty"`
+
+	// InsecureSkipVerify disables verification of servers. Don't use
+	// this except for tests.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// Config creates a tls.Config from the configuration.
+func (t *TLS) Config() (*tls.Config, error) {
+	c := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		b, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates in %s", t.CAFile)
+		}
+		c.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, errors.New("both \"cert_file\" and \"key_file\" are required")
+		}
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
@@ -0,0 +1,144 @@
+package seri
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/koron/serinin/internal/tlstest"
+)
+
+// handshake connects to a TLS stand-in with a configuration, and returns the
+// state of the connection on server side.
+func handshake(t *testing.T, server, client *tls.Config) (tls.ConnectionState, error) {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ch := make(chan tls.ConnectionState, 1)
+	go func() {
+		defer close(ch)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tc := conn.(*tls.Conn)
+		if tc.Handshake() != nil {
+			return
+		}
+		ch <- tc.ConnectionState()
+	}()
+	conn, err := tls.Dial("tcp", ln.Addr().String(), client)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	// TLS 1.3 reports rejection of client certificates at first read.
+	conn.Write([]byte{0})
+	st, ok := <-ch
+	if !ok {
+		return st, net.ErrClosed
+	}
+	return st, nil
+}
+
+func TestTLSConfig(t *testing.T) {
+	certs := tlstest.New(t)
+	tc, err := (&TLS{
+		CAFile:     certs.CAFile,
+		CertFile:   certs.ClientCertFile,
+		KeyFile:    certs.ClientKeyFile,
+		ServerName: tlstest.ServerName,
+	}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := handshake(t, certs.ServerConfig(true), tc)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if st.ServerName != tlstest.ServerName {
+		t.Errorf("unexpected server name: want=%s got=%s", tlstest.ServerName, st.ServerName)
+	}
+	if len(st.PeerCertificates) == 0 {
+		t.Fatal("no client certificates are presented")
+	}
+	if cn := st.PeerCertificates[0].Subject.CommonName; cn != "client" {
+		t.Errorf("unexpected client certificate: %s", cn)
+	}
+}
+
+func TestTLSConfigServerName(t *testing.T) {
+	certs := tlstest.New(t)
+	tc, err := (&TLS{CAFile: certs.CAFile}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server certificate is not valid for 127.0.0.1
+	if _, err := handshake(t, certs.ServerConfig(false), tc); err == nil {
+		t.Error("handshake should fail without server_name")
+	}
+	tc, err = (&TLS{CAFile: certs.CAFile, ServerName: "other.test"}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, certs.ServerConfig(false), tc); err == nil {
+		t.Error("handshake should fail with wrong server_name")
+	}
+}
+
+func TestTLSConfigUnknownCA(t *testing.T) {
+	certs := tlstest.New(t)
+	other := tlstest.New(t)
+	tc, err := (&TLS{CAFile: other.CAFile, ServerName: tlstest.ServerName}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, certs.ServerConfig(false), tc); err == nil {
+		t.Error("handshake should fail with unknown CA")
+	}
+}
+
+func TestTLSConfigInsecureSkipVerify(t *testing.T) {
+	certs := tlstest.New(t)
+	tc, err := (&TLS{InsecureSkipVerify: true}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, certs.ServerConfig(false), tc); err != nil {
+		t.Errorf("handshake failed: %s", err)
+	}
+}
+
+func TestTLSConfigNoClientCert(t *testing.T) {
+	certs := tlstest.New(t)
+	tc, err := (&TLS{CAFile: certs.CAFile, ServerName: tlstest.ServerName}).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, certs.ServerConfig(true), tc); err == nil {
+		t.Error("server should reject clients without certificates")
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	certs := tlstest.New(t)
+	for _, tc := range []struct {
+		name string
+		cfg  *TLS
+	}{
+		{"no key_file", &TLS{CertFile: certs.ClientCertFile}},
+		{"no cert_file", &TLS{KeyFile: certs.ClientKeyFile}},
+		{"missing ca_file", &TLS{CAFile: certs.CAFile + ".missing"}},
+		{"invalid ca_file", &TLS{CAFile: certs.ClientKeyFile}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.cfg.Config(); err == nil {
+				t.Error("should fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package sqlstore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dialect provides differences among databases, to create tables and to
+// write queries.
+type dialect struct {
+	// dollar uses "$1", "$2", ... as placeholders instead of "?".
+	dollar bool
+
+	// blob is a type of binary column.
+	blob string
+
+	// inlineIndex declares indexes in "CREATE TABLE", for databases which
+	// don't support "CREATE INDEX IF NOT EXISTS".
+	inlineIndex bool
+}
+
+var (
+	sqliteDialect   = &dialect{blob: "BLOB"}
+	postgresDialect = &dialect{dollar: true, blob: "BYTEA"}
+	mysqlDialect    = &dialect{blob: "LONGBLOB", inlineIndex: true}
+)
+
+// dialects maps names of database/sql drivers to their dialects.
+var dialects = map[string]*dialect{
+	"sqlite3":  sqliteDialect,
+	"sqlite":   sqliteDialect,
+	"postgres": postgresDialect,
+	"pgx":      postgresDialect,
+	"mysql":    mysqlDialect,
+}
+
+// driverDialect returns a dialect of the driver. Unknown drivers are
+// available only with "no_create_tables", because queries to create tables
+// can't be determined for them.
+func driverDialect(driver string, noCreateTables bool) (*dialect, error) {
+	if d, ok := dialects[driver]; ok {
+		return d, nil
+	}
+	if noCreateTables {
+		return sqliteDialect, nil
+	}
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, fmt.Sprintf("%q", name))
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("\"driver\" %q requires \"no_create_tables\", tables are created only for %s", driver, strings.Join(names, ", "))
+}
+
+// createTableQueries returns queries to create tables and indexes if not
+// exist.
+func (d *dialect) createTableQueries(requests, results string) []string {
+	index := func(table string) (inline string, create []string) {
+		name := table + "_created_at"
+		if d.inlineIndex {
+			return ",\n\t\t\tINDEX " + name + " (created_at)", nil
+		}
+		return "", []string{`CREATE INDEX IF NOT EXISTS ` + name + ` ON ` + table + ` (created_at)`}
+	}
+	reqIndex, reqCreate := index(requests)
+	resIndex, resCreate := index(results)
+	qq := []string{
+		`CREATE TABLE IF NOT EXISTS ` + requests + ` (
+			id VARCHAR(64) NOT NULL PRIMARY KEY,
+			method VARCHAR(16) NOT NULL,
+			url TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			completed_at BIGINT` + reqIndex + `
+		)`,
+	}
+	qq = append(qq, reqCreate...)
+	qq = append(qq, `CREATE TABLE IF NOT EXISTS `+results+` (
+			request_id VARCHAR(64) NOT NULL,
+			endpoint VARCHAR(255) NOT NULL,
+			data `+d.blob+`,
+			status INTEGER,
+			latency_ms BIGINT,
+			content_type VARCHAR(255),
+			mark VARCHAR(32),
+			created_at BIGINT NOT NULL,
+			PRIMARY KEY (request_id, endpoint)`+resIndex+`
+		)`)
+	return append(qq, resCreate...)
+}
@@ -0,0 +1,91 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+// ListRequests lists requests with results, in order from newest. Time range
+// and method are filtered by the query, and other filters are applied to
+// rows while reading them.
+func (ss *storage) ListRequests(ctx context.Context, opts *seri.ListOptions) ([]*seri.RequestInfo, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if !opts.Since.IsZero() {
+		conds = append(conds, `r.created_at >= ?`)
+		args = append(args, msec(opts.Since))
+	}
+	if !opts.Until.IsZero() {
+		conds = append(conds, `r.created_at < ?`)
+		args = append(args, msec(opts.Until))
+	}
+	if opts.Method != "" {
+		conds = append(conds, `r.method = ?`)
+		args = append(args, strings.ToUpper(opts.Method))
+	}
+	q := `SELECT r.id, r.method, r.url, r.created_at, s.endpoint FROM ` + ss.requests + ` r LEFT JOIN ` + ss.results + ` s ON s.request_id = r.id AND s.data IS NOT NULL`
+	if len(conds) > 0 {
+		q += ` WHERE ` + strings.Join(conds, ` AND `)
+	}
+	q += ` ORDER BY r.created_at DESC, r.id`
+	rows, err := ss.db.QueryContext(ctx, ss.rebind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	max := opts.MaxCount()
+	var (
+		list []*seri.RequestInfo
+		curr *seri.RequestInfo
+	)
+	// flush adds the current request to the list, it returns true when the
+	// list is full.
+	flush := func() bool {
+		if curr == nil {
+			return false
+		}
+		sort.Strings(curr.Results)
+		if opts.Match(curr) {
+			list = append(list, curr)
+		}
+		curr = nil
+		return len(list) >= max
+	}
+	for rows.Next() {
+		var (
+			id, method, url string
+			createdAt       int64
+			endpoint        sql.NullString
+		)
+		if err := rows.Scan(&id, &method, &url, &createdAt, &endpoint); err != nil {
+			return nil, err
+		}
+		if curr == nil || curr.ID != id {
+			if flush() {
+				return list, nil
+			}
+			curr = &seri.RequestInfo{
+				ID:        id,
+				Method:    method,
+				URL:       url,
+				CreatedAt: time.Unix(0, createdAt*int64(time.Millisecond)),
+			}
+		}
+		if endpoint.Valid {
+			curr.Results = append(curr.Results, endpoint.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return list, nil
+}
@@ -0,0 +1,45 @@
+package sqlstore
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+func (ss *storage) retentionLoop(interval time.Duration) {
+	defer ss.wg.Done()
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ss.done:
+			return
+		case now := <-tk.C:
+			n, err := ss.deleteExpired(context.Background(), now)
+			if err != nil {
+				log.Printf("[WARN] sqlstore: retention failed: %s", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("[INFO] sqlstore: retention deleted %d rows", n)
+			}
+		}
+	}
+}
+
+// deleteExpired deletes rows of requests and results, which are older than
+// "expire_in". It returns number of deleted rows.
+func (ss *storage) deleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	limit := msec(now.Add(-ss.expiresIn))
+	var total int64
+	for _, table := range []string{ss.results, ss.requests} {
+		r, err := ss.db.ExecContext(ctx, ss.rebind(`DELETE FROM `+table+` WHERE created_at < ?`), limit)
+		if err != nil {
+			return total, err
+		}
+		if n, err := r.RowsAffected(); err == nil {
+			total += n
+		}
+	}
+	return total, nil
+}
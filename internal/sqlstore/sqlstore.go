@@ -0,0 +1,308 @@
+/*
+Package sqlstore provides a store which writes requests and results to
+relational database tables with database/sql.
+*/
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+
+	// load sqlite3 driver, the default driver.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DefaultDriver is name of default database/sql driver.
+const DefaultDriver = "sqlite3"
+
+type storage struct {
+	db        *sql.DB
+	dialect   *dialect
+	dollar    bool
+	requests  string
+	results   string
+	expiresIn time.Duration
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+var (
+	_ seri.Storage      = (*storage)(nil)
+	_ seri.Marker       = (*storage)(nil)
+	_ seri.Completer    = (*storage)(nil)
+	_ seri.ResultStorer = (*storage)(nil)
+	_ seri.BatchStorer  = (*storage)(nil)
+	_ seri.Pinger       = (*storage)(nil)
+	_ seri.Lister       = (*storage)(nil)
+	_ seri.Deleter      = (*storage)(nil)
+)
+
+func newStorage(cfg *seri.SQL) (*storage, error) {
+	if cfg == nil {
+		return nil, errors.New("\"sql\" configuration is not available")
+	}
+	if cfg.DSN == "" {
+		return nil, errors.New("\"dsn\" of \"sql\" is required")
+	}
+	driver := cfg.Driver
+	if driver == "" {
+		driver = DefaultDriver
+	}
+	d, err := driverDialect(driver, cfg.NoCreateTables)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driver, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	maxConns := cfg.MaxOpenConns
+	if maxConns <= 0 && driver == DefaultDriver {
+		// sqlite3 allows only one writer at a time.
+		maxConns = 1
+	}
+	db.SetMaxOpenConns(maxConns)
+	ss := &storage{
+		db:        db,
+		dialect:   d,
+		dollar:    cfg.Placeholder == "$" || (cfg.Placeholder == "" && d.dollar),
+		requests:  cfg.TablePrefix + "requests",
+		results:   cfg.TablePrefix + "results",
+		expiresIn: time.Duration(cfg.ExpireIn),
+		done:      make(chan struct{}),
+	}
+	if !cfg.NoCreateTables {
+		err := ss.createTables(context.Background())
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create tables: %w", err)
+		}
+	}
+	if ss.expiresIn > 0 {
+		interval := time.Duration(cfg.RetentionInterval)
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		ss.wg.Add(1)
+		go ss.retentionLoop(interval)
+	}
+	return ss, nil
+}
+
+// createTables creates tables and indexes if not exist. Times are stored as
+// milliseconds of UNIX time.
+func (ss *storage) createTables(ctx context.Context) error {
+	for _, q := range ss.dialect.createTableQueries(ss.requests, ss.results) {
+		if _, err := ss.db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebind replaces "?" placeholders in a query with "$1", "$2", ... for
+// drivers which require them.
+func (ss *storage) rebind(q string) string {
+	if !ss.dollar {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+func msec(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (ss *storage) StoreRequest(ctx context.Context, reqid, method, url string) error {
+	_, err := ss.db.ExecContext(ctx, ss.rebind(`INSERT INTO `+ss.requests+` (id, method, url, created_at) VALUES (?, ?, ?, ?)`),
+		reqid, method, url, msec(time.Now()))
+	return err
+}
+
+func (ss *storage) StoreResponse(ctx context.Context, reqid, name string, data []byte) error {
+	return ss.StoreResult(ctx, reqid, name, data, seri.ResultInfo{})
+}
+
+func (ss *storage) StoreResult(ctx context.Context, reqid, name string, data []byte, info seri.ResultInfo) error {
+	return ss.StoreResponses(ctx, []seri.BatchItem{{ReqID: reqid, Name: name, Data: data, Info: info}})
+}
+
+// StoreResponses stores results of a batch in a transaction.
+func (ss *storage) StoreResponses(ctx context.Context, items []seri.BatchItem) error {
+	return ss.withTx(ctx, func(tx *sql.Tx) error {
+		now := msec(time.Now())
+		for _, it := range items {
+			var status, latency, contentType interface{}
+			if it.Info.Status != 0 {
+				status = it.Info.Status
+				latency = it.Info.Latency.Milliseconds()
+			}
+			if it.Info.ContentType != "" {
+				contentType = it.Info.ContentType
+			}
+			err := ss.upsert(ctx, tx, it.ReqID, it.Name,
+				`data = ?, status = ?, latency_ms = ?, content_type = ?`,
+				[]string{"data", "status", "latency_ms", "content_type"},
+				[]interface{}{it.Data, status, latency, contentType}, now)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ss *storage) MarkResponse(ctx context.Context, reqid, name, mark string) error {
+	return ss.withTx(ctx, func(tx *sql.Tx) error {
+		return ss.upsert(ctx, tx, reqid, name, `mark = ?`,
+			[]string{"mark"}, []interface{}{mark}, msec(time.Now()))
+	})
+}
+
+// upsert updates columns of a row of results table, or inserts a row when
+// it doesn't exist. It is portable among databases unlike "UPSERT" syntax.
+func (ss *storage) upsert(ctx context.Context, tx *sql.Tx, reqid, name, set string, columns []string, values []interface{}, now int64) error {
+	args := append(append([]interface{}{}, values...), reqid, name)
+	r, err := tx.ExecContext(ctx, ss.rebind(`UPDATE `+ss.results+` SET `+set+` WHERE request_id = ? AND endpoint = ?`), args...)
+	if err != nil {
+		return err
+	}
+	if n, err := r.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+	q := `INSERT INTO ` + ss.results + ` (request_id, endpoint, created_at, ` + strings.Join(columns, ", ") + `) VALUES (?, ?, ?` + strings.Repeat(", ?", len(columns)) + `)`
+	args = append([]interface{}{reqid, name, now}, values...)
+	_, err = tx.ExecContext(ctx, ss.rebind(q), args...)
+	return err
+}
+
+func (ss *storage) withTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ss *storage) CompleteRequest(ctx context.Context, reqid string) error {
+	_, err := ss.db.ExecContext(ctx, ss.rebind(`UPDATE `+ss.requests+` SET completed_at = ? WHERE id = ?`),
+		msec(time.Now()), reqid)
+	return err
+}
+
+func (ss *storage) GetResponse(ctx context.Context, reqid string) (*seri.Response, error) {
+	r := &seri.Response{
+		Results: make(map[string]*seri.Result),
+	}
+	err := ss.db.QueryRowContext(ctx, ss.rebind(`SELECT id, method, url FROM `+ss.requests+` WHERE id = ?`), reqid).
+		Scan(&r.ID, &r.Method, &r.URL)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+		}
+		return nil, err
+	}
+	rows, err := ss.db.QueryContext(ctx, ss.rebind(`SELECT endpoint, data, content_type, mark FROM `+ss.results+` WHERE request_id = ?`), reqid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name        string
+			data        []byte
+			contentType sql.NullString
+			mark        sql.NullString
+		)
+		if err := rows.Scan(&name, &data, &contentType, &mark); err != nil {
+			return nil, err
+		}
+		if mark.Valid && mark.String != "" {
+			if r.Marks == nil {
+				r.Marks = make(map[string]string)
+			}
+			r.Marks[name] = mark.String
+		}
+		if data == nil {
+			continue
+		}
+		d, err := seri.DecodeValue(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode result of %s: %w", name, err)
+		}
+		r.Results[name] = &seri.Result{Data: d, ContentType: contentType.String}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// DeleteRequest deletes a request and its results in a transaction.
+func (ss *storage) DeleteRequest(ctx context.Context, reqid string) error {
+	return ss.withTx(ctx, func(tx *sql.Tx) error {
+		var n int64
+		for _, q := range []string{
+			`DELETE FROM ` + ss.results + ` WHERE request_id = ?`,
+			`DELETE FROM ` + ss.requests + ` WHERE id = ?`,
+		} {
+			r, err := tx.ExecContext(ctx, ss.rebind(q), reqid)
+			if err != nil {
+				return err
+			}
+			if m, err := r.RowsAffected(); err == nil {
+				n += m
+			}
+		}
+		if n == 0 {
+			return fmt.Errorf("%w: %s", seri.ErrNotFound, reqid)
+		}
+		return nil
+	})
+}
+
+func (ss *storage) Ping(ctx context.Context) error {
+	return ss.db.PingContext(ctx)
+}
+
+// Close stops the retention job and closes the database.
+func (ss *storage) Close() error {
+	var err error
+	ss.closeOnce.Do(func() {
+		close(ss.done)
+		ss.wg.Wait()
+		err = ss.db.Close()
+	})
+	return err
+}
+
+func init() {
+	seri.RegisterStorage("sql", func(cfg *seri.Config) (seri.Storage, error) {
+		return newStorage(cfg.SQL)
+	})
+}
@@ -0,0 +1,305 @@
+package sqlstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koron/serinin/internal/seri"
+)
+
+func newTestStorage(t *testing.T, cfg *seri.SQL) *storage {
+	t.Helper()
+	if cfg.DSN == "" {
+		cfg.DSN = "file:" + filepath.Join(t.TempDir(), "serinin.sqlite3")
+	}
+	ss, err := newStorage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ss.Close() })
+	return ss
+}
+
+func TestCreateTables(t *testing.T) {
+	cfg := &seri.SQL{TablePrefix: "serinin_"}
+	ss := newTestStorage(t, cfg)
+	for _, q := range []string{
+		`SELECT id, method, url, created_at, completed_at FROM serinin_requests`,
+		`SELECT request_id, endpoint, data, status, latency_ms, content_type, mark, created_at FROM serinin_results`,
+	} {
+		if _, err := ss.db.Exec(q); err != nil {
+			t.Errorf("table is not created: %s", err)
+		}
+	}
+	var n int
+	err := ss.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name LIKE '%_created_at'`).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("unexpected number of indexes: %d", n)
+	}
+	// tables are created again without errors.
+	if err := ss.createTables(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDialects(t *testing.T) {
+	for _, tc := range []struct {
+		driver    string
+		blob      string
+		createIdx bool
+		inlineIdx bool
+		dollar    bool
+	}{
+		{driver: "sqlite3", blob: "data BLOB", createIdx: true},
+		{driver: "postgres", blob: "data BYTEA", createIdx: true, dollar: true},
+		{driver: "pgx", blob: "data BYTEA", createIdx: true, dollar: true},
+		{driver: "mysql", blob: "data LONGBLOB", inlineIdx: true},
+	} {
+		d, err := driverDialect(tc.driver, false)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.driver, err)
+		}
+		if d.dollar != tc.dollar {
+			t.Errorf("%s: unexpected placeholder: dollar=%t", tc.driver, d.dollar)
+		}
+		qq := strings.Join(d.createTableQueries("requests", "results"), ";\n")
+		if !strings.Contains(qq, tc.blob) {
+			t.Errorf("%s: %q is not found in:\n%s", tc.driver, tc.blob, qq)
+		}
+		if got := strings.Contains(qq, "CREATE INDEX IF NOT EXISTS"); got != tc.createIdx {
+			t.Errorf("%s: unexpected CREATE INDEX: %t\n%s", tc.driver, got, qq)
+		}
+		if got := strings.Contains(qq, "INDEX results_created_at (created_at)"); got != tc.inlineIdx {
+			t.Errorf("%s: unexpected inline index: %t\n%s", tc.driver, got, qq)
+		}
+	}
+
+	if _, err := driverDialect("oracle", false); err == nil {
+		t.Error("unknown driver should require no_create_tables")
+	}
+	if _, err := driverDialect("oracle", true); err != nil {
+		t.Errorf("unknown driver with no_create_tables: %s", err)
+	}
+	if _, err := newStorage(&seri.SQL{Driver: "oracle", DSN: "x"}); err == nil {
+		t.Error("unknown driver should be rejected")
+	}
+}
+
+func TestStoreAndGet(t *testing.T) {
+	ss := newTestStorage(t, &seri.SQL{})
+	ctx := context.Background()
+	if err := ss.StoreRequest(ctx, "r1", "POST", "/foo?a=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.StoreResult(ctx, "r1", "ep1", []byte("hello"), seri.ResultInfo{
+		Status:      200,
+		Latency:     10 * time.Millisecond,
+		ContentType: "text/plain",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	err := ss.StoreResponses(ctx, []seri.BatchItem{
+		{ReqID: "r1", Name: "ep2", Data: []byte("batch2")},
+		{ReqID: "r1", Name: "ep3", Data: []byte("batch3")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.MarkResponse(ctx, "r1", "ep4", seri.MarkAborted); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.CompleteRequest(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ss.GetResponse(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != "r1" || r.Method != "POST" || r.URL != "/foo?a=1" {
+		t.Errorf("unexpected request: %+v", r)
+	}
+	if len(r.Results) != 3 {
+		t.Errorf("unexpected number of results: %d", len(r.Results))
+	}
+	for name, want := range map[string]string{"ep1": "hello", "ep2": "batch2", "ep3": "batch3"} {
+		if got := r.Results[name]; got == nil || string(got.Data) != want {
+			t.Errorf("unexpected result of %s: %+v", name, got)
+		}
+	}
+	if ct := r.Results["ep1"].ContentType; ct != "text/plain" {
+		t.Errorf("unexpected content type: %q", ct)
+	}
+	if len(r.Marks) != 1 || r.Marks["ep4"] != seri.MarkAborted {
+		t.Errorf("unexpected marks: %+v", r.Marks)
+	}
+
+	var status, latency int64
+	var completed sql.NullInt64
+	err = ss.db.QueryRow(`SELECT status, latency_ms FROM results WHERE request_id = 'r1' AND endpoint = 'ep1'`).Scan(&status, &latency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 200 || latency != 10 {
+		t.Errorf("unexpected status and latency: %d %d", status, latency)
+	}
+	if err := ss.db.QueryRow(`SELECT completed_at FROM requests WHERE id = 'r1'`).Scan(&completed); err != nil {
+		t.Fatal(err)
+	}
+	if !completed.Valid {
+		t.Error("completed_at is not set")
+	}
+
+	if _, err := ss.GetResponse(ctx, "r2"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error for unknown request: %v", err)
+	}
+}
+
+func TestBinaryResult(t *testing.T) {
+	ss := newTestStorage(t, &seri.SQL{})
+	ctx := context.Background()
+	data := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff, 0xfe}
+	if err := ss.StoreRequest(ctx, "r1", "GET", "/image"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.StoreResult(ctx, "r1", "ep1", data, seri.ResultInfo{ContentType: "image/png"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := ss.GetResponse(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := r.Results["ep1"]
+	if got == nil || !bytes.Equal(got.Data, data) || got.ContentType != "image/png" {
+		t.Errorf("unexpected result: %+v", got)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	ss := newTestStorage(t, &seri.SQL{})
+	ctx := context.Background()
+	if err := ss.StoreRequest(ctx, "r1", "GET", "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	// a mark and a result of the same endpoint share a row.
+	if err := ss.MarkResponse(ctx, "r1", "ep1", seri.MarkTruncated); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.StoreResponse(ctx, "r1", "ep1", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.StoreResponse(ctx, "r1", "ep1", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := ss.db.QueryRow(`SELECT COUNT(*) FROM results`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("unexpected number of rows: %d", n)
+	}
+	r, err := ss.GetResponse(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Results["ep1"]; got == nil || string(got.Data) != "second" {
+		t.Errorf("result is not updated: %+v", got)
+	}
+	if r.Marks["ep1"] != seri.MarkTruncated {
+		t.Errorf("mark is lost: %+v", r.Marks)
+	}
+}
+
+func TestDeleteRequest(t *testing.T) {
+	ss := newTestStorage(t, &seri.SQL{})
+	ctx := context.Background()
+	if err := ss.StoreRequest(ctx, "r1", "GET", "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.StoreResponse(ctx, "r1", "ep1", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.DeleteRequest(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.GetResponse(ctx, "r1"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("deleted request is found: %v", err)
+	}
+	if err := ss.DeleteRequest(ctx, "r1"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("unexpected error for deleted request: %v", err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	ss := newTestStorage(t, &seri.SQL{
+		ExpireIn:          seri.Duration(time.Hour),
+		RetentionInterval: seri.Duration(time.Hour),
+	})
+	ctx := context.Background()
+	for _, id := range []string{"old", "new"} {
+		if err := ss.StoreRequest(ctx, id, "GET", "/"+id); err != nil {
+			t.Fatal(err)
+		}
+		if err := ss.StoreResponse(ctx, id, "ep1", []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := msec(time.Now().Add(-2 * time.Hour))
+	for _, q := range []string{
+		`UPDATE requests SET created_at = ? WHERE id = 'old'`,
+		`UPDATE results SET created_at = ? WHERE request_id = 'old'`,
+	} {
+		if _, err := ss.db.Exec(q, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := ss.deleteExpired(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("unexpected number of deleted rows: %d", n)
+	}
+	if _, err := ss.GetResponse(ctx, "old"); !errors.Is(err, seri.ErrNotFound) {
+		t.Errorf("expired request is found: %v", err)
+	}
+	if _, err := ss.GetResponse(ctx, "new"); err != nil {
+		t.Errorf("request is deleted before expiration: %s", err)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	ss := &storage{dollar: true}
+	got := ss.rebind(`UPDATE t SET a = ? WHERE b = ? AND c = ?`)
+	if want := `UPDATE t SET a = $1 WHERE b = $2 AND c = $3`; got != want {
+		t.Errorf("unexpected query:\nwant=%s\ngot=%s", want, got)
+	}
+}
+
+func TestRetentionLoop(t *testing.T) {
+	ss := newTestStorage(t, &seri.SQL{
+		ExpireIn:          seri.Duration(time.Millisecond),
+		RetentionInterval: seri.Duration(10 * time.Millisecond),
+	})
+	ctx := context.Background()
+	if err := ss.StoreRequest(ctx, "r1", "GET", "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if _, err := ss.GetResponse(ctx, "r1"); errors.Is(err, seri.ErrNotFound) {
+			return
+		}
+	}
+	t.Fatal("expired request is not deleted by the retention job")
+}
@@ -6,7 +6,10 @@ package storages
 import (
 	// load and register drivers of store for serinin.
 	_ "github.com/koron/serinin/internal/binmemcachestore"
+	_ "github.com/koron/serinin/internal/boltstore"
 	_ "github.com/koron/serinin/internal/gocachestore"
 	_ "github.com/koron/serinin/internal/memcachestore"
+	_ "github.com/koron/serinin/internal/multistore"
 	_ "github.com/koron/serinin/internal/redisstore"
+	_ "github.com/koron/serinin/internal/sqlstore"
 )
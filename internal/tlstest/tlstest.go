@@ -0,0 +1,126 @@
+// Package tlstest provides certificates to test TLS connections to stores.
+package tlstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ServerName is a name of servers in certificates.
+const ServerName = "store.test"
+
+// Certs is a set of CA, server and client certificates. Each of them is
+// written to a PEM file in a temporary directory.
+type Certs struct {
+	CAFile string
+
+	ServerCertFile string
+	ServerKeyFile  string
+
+	ClientCertFile string
+	ClientKeyFile  string
+
+	pool   *x509.CertPool
+	server tls.Certificate
+}
+
+// New creates a new set of certificates. The server certificate is valid
+// only for ServerName, not for IP addresses.
+func New(tb testing.TB) *Certs {
+	tb.Helper()
+	dir := tb.TempDir()
+	caKey := newKey(tb)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tlstest CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	c := &Certs{
+		CAFile: writePEM(tb, dir, "ca.pem", "CERTIFICATE", caDER),
+		pool:   x509.NewCertPool(),
+	}
+	c.pool.AddCert(ca)
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage, dnsNames []string) (string, string, tls.Certificate) {
+		key := newKey(tb)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     dnsNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		certFile := writePEM(tb, dir, name+".pem", "CERTIFICATE", der)
+		keyFile := writePEM(tb, dir, name+"-key.pem", "EC PRIVATE KEY", keyDER)
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return certFile, keyFile, cert
+	}
+	c.ServerCertFile, c.ServerKeyFile, c.server = issue(2, "server", x509.ExtKeyUsageServerAuth, []string{ServerName})
+	c.ClientCertFile, c.ClientKeyFile, _ = issue(3, "client", x509.ExtKeyUsageClientAuth, nil)
+	return c
+}
+
+// ServerConfig returns a configuration for servers. When requireClient is
+// true, servers require client certificates which are signed by the CA.
+func (c *Certs) ServerConfig(requireClient bool) *tls.Config {
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{c.server},
+	}
+	if requireClient {
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+		tc.ClientCAs = c.pool
+	}
+	return tc
+}
+
+func newKey(tb testing.TB) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return key
+}
+
+func writePEM(tb testing.TB, dir, name, typ string, der []byte) string {
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/koron-go/sigctx"
@@ -15,7 +16,7 @@ import (
 )
 
 func main() {
-	ctx, cancel := sigctx.WithCancelSignal(context.Background(), os.Interrupt)
+	ctx, cancel := sigctx.WithCancelSignal(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 	err := run(ctx)
 	if err != nil {
@@ -32,6 +33,27 @@ func logSystemMetrics(b *seri.Broker) {
 	if st.StoreTimeout > 0 {
 		log.Printf("[WARN] storing %d results are timeouted, check load of the storage", st.StoreTimeout)
 	}
+	if st.InquireAborted > 0 {
+		log.Printf("[WARN] %d inquiries are aborted by shutdown", st.InquireAborted)
+	}
+	if st.InquireTruncated > 0 {
+		log.Printf("[WARN] %d responses are truncated, check max_response_size", st.InquireTruncated)
+	}
+	if st.RequestLimited > 0 {
+		log.Printf("[WARN] %d requests are rejected by rate limit", st.RequestLimited)
+	}
+	if st.InquireLimited > 0 {
+		log.Printf("[WARN] %d inquiries are dropped by rate limit of endpoints", st.InquireLimited)
+	}
+	if st.StoreBuffered > 0 {
+		log.Printf("[WARN] %d writes are buffered by degraded mode, check the storage", st.StoreBuffered)
+	}
+	if st.StoreReplayed > 0 {
+		log.Printf("[INFO] %d buffered writes are replayed", st.StoreReplayed)
+	}
+	if st.StoreDropped > 0 {
+		log.Printf("[WARN] %d buffered writes are dropped, they failed to be replayed repeatedly", st.StoreDropped)
+	}
 
 	// verbose monitoring
 	ngo := runtime.NumGoroutine()